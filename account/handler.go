@@ -0,0 +1,179 @@
+package account
+
+import (
+	"dataShare/core"
+	"dataShare/service"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+const (
+	minPasswordLength = 12
+	apiKeyPrefix      = "dsk_"
+	maxAPIKeyName     = 100
+)
+
+// dummyHash is compared against when the user does not exist, so that a login takes
+// the same time whether the email is registered or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+type Handler struct {
+	c  echo.Context
+	DB *gorm.DB
+	e  *service.Encryption
+}
+
+func NewHandler(c echo.Context, db *gorm.DB, e *service.Encryption) *Handler {
+	return &Handler{c: c, DB: db, e: e}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func (h *Handler) SignUp(email, password string) (*User, error) {
+	email = normalizeEmail(email)
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, core.NewError(http.StatusBadRequest, 4000, "Invalid email address")
+	}
+	if len(password) < minPasswordLength {
+		return nil, core.NewError(http.StatusBadRequest, 4010, "Password must be at least 12 characters long")
+	}
+
+	ur := NewRepositoryImp(h.DB)
+	if _, err := ur.FindUserByEmail(email); err == nil {
+		return nil, core.NewError(http.StatusConflict, 4020, "Email address is already registered")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 4030, "Can't create account")
+	}
+	u := &User{
+		ID:           service.NewID("usr"),
+		Email:        email,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+	if err := ur.SaveUser(u); err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 4030, "Can't create account")
+	}
+	return u, nil
+}
+
+func (h *Handler) Login(email, password string) (*User, error) {
+	ur := NewRepositoryImp(h.DB)
+	u, err := ur.FindUserByEmail(normalizeEmail(email))
+	if err != nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, core.NewError(http.StatusUnauthorized, 4040, "Wrong email or password")
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, core.NewError(http.StatusUnauthorized, 4040, "Wrong email or password")
+	}
+	return u, nil
+}
+
+// ExternalLogin returns the account linked to the subject of an identity provider, creating it
+// on the first sign in. Such accounts have no password and can't use the password login.
+// Accounts are never linked by email, so that an identity provider can't take over a password account;
+// only the accounts signed in by email before they were linked, which have no password, are linked once.
+func (h *Handler) ExternalLogin(issuer, subject, email string) (*User, error) {
+	email = normalizeEmail(email)
+	if _, err := mail.ParseAddress(email); err != nil {
+		return nil, core.NewError(http.StatusBadRequest, 4000, "Invalid email address")
+	}
+
+	ur := NewRepositoryImp(h.DB)
+	if u, err := ur.FindUserByIdentity(issuer, subject); err == nil {
+		return u, nil
+	}
+	if u, err := ur.FindUserByEmail(email); err == nil {
+		if u.PasswordHash != "" || u.Issuer != "" {
+			return nil, core.NewError(http.StatusConflict, 4140, "Email address is already used by another account")
+		}
+		if err := ur.LinkUser(u, issuer, subject); err != nil {
+			return nil, core.NewError(http.StatusUnprocessableEntity, 4030, "Can't create account")
+		}
+		return u, nil
+	}
+	u := &User{
+		ID:        service.NewID("usr"),
+		Email:     email,
+		Issuer:    issuer,
+		Subject:   subject,
+		CreatedAt: time.Now(),
+	}
+	if err := ur.SaveUser(u); err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 4030, "Can't create account")
+	}
+	return u, nil
+}
+
+// CreateAPIKey returns the new key in clear text, it can't be recovered later.
+func (h *Handler) CreateAPIKey(u *User, name string) (string, *APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyName {
+		return "", nil, core.NewError(http.StatusBadRequest, 4050, "API key name must be between 1 and 100 characters")
+	}
+	secret, err := service.GenerateManageToken()
+	if err != nil {
+		return "", nil, core.NewError(http.StatusUnprocessableEntity, 4060, "Can't create API key")
+	}
+	key := apiKeyPrefix + secret
+	k := &APIKey{
+		ID:        service.NewID("key"),
+		UserID:    u.ID,
+		Name:      name,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		KeyHash:   h.e.HashString(key),
+		CreatedAt: time.Now(),
+	}
+	if err := NewRepositoryImp(h.DB).SaveAPIKey(k); err != nil {
+		return "", nil, core.NewError(http.StatusUnprocessableEntity, 4060, "Can't create API key")
+	}
+	return key, k, nil
+}
+
+func (h *Handler) APIKeys(u *User) ([]APIKey, error) {
+	keys, err := NewRepositoryImp(h.DB).GetAPIKeys(u.ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 4070, "Can't get API keys")
+	}
+	return keys, nil
+}
+
+func (h *Handler) RevokeAPIKey(u *User, id string) error {
+	err := NewRepositoryImp(h.DB).RevokeAPIKey(u.ID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return core.NewError(http.StatusNotFound, 4080, "Can't find API key")
+	}
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 4090, "Can't revoke API key")
+	}
+	return nil
+}
+
+// UserByAPIKey returns the owner of an active API key.
+func (h *Handler) UserByAPIKey(key string) (*User, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, core.NewError(http.StatusUnauthorized, 4100, "Invalid API key")
+	}
+	ur := NewRepositoryImp(h.DB)
+	k, err := ur.FindAPIKeyByHash(h.e.HashString(key))
+	if err != nil {
+		return nil, core.NewError(http.StatusUnauthorized, 4100, "Invalid API key")
+	}
+	u, err := ur.FindUserById(k.UserID)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnauthorized, 4100, "Invalid API key")
+	}
+	_ = ur.TouchAPIKey(k.ID)
+	return u, nil
+}
@@ -0,0 +1,93 @@
+package account
+
+import (
+	"dataShare/core"
+	"dataShare/service"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+)
+
+// CurrentUser returns the signed-in user set by Authenticate, or nil.
+func CurrentUser(c echo.Context) *User {
+	u, _ := c.Get("user").(*User)
+	return u
+}
+
+// Authenticate sets the signed-in user under the "user" context key. API routes, under /api/,
+// only accept "Authorization: Bearer <API key>" so that they can't be forged with the session cookie;
+// the other routes only use the session cookie.
+func Authenticate(db *gorm.DB, e *service.Encryption, sessions *Sessions) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			h := NewHandler(c, db, e)
+			if strings.HasPrefix(c.Path(), "/api/") {
+				auth := c.Request().Header.Get(echo.HeaderAuthorization)
+				if key, ok := strings.CutPrefix(auth, "Bearer "); ok {
+					u, err := h.UserByAPIKey(key)
+					if err != nil {
+						return err
+					}
+					c.Set("user", u)
+				}
+				return next(c)
+			}
+
+			if userID, ok := sessions.Get(c); ok {
+				if u, err := NewRepositoryImp(db).FindUserById(userID); err == nil {
+					c.Set("user", u)
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// RequireUser rejects anonymous requests. Pages redirect to loginURL, API routes get an error.
+func RequireUser(loginURL string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if CurrentUser(c) != nil {
+				return next(c)
+			}
+			if loginURL != "" && c.Request().Method == http.MethodGet {
+				return c.Redirect(http.StatusSeeOther, loginURL)
+			}
+			return core.NewError(http.StatusUnauthorized, 4110, "Sign in first")
+		}
+	}
+}
+
+// RequireIssuer only lets through users linked to the OpenID Connect issuer. The password accounts, and
+// their API keys, created before single sign-on was enabled were never checked against the allowed
+// domains and groups. Anonymous requests are handled like RequireUser.
+func RequireIssuer(issuer, loginURL string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			u := CurrentUser(c)
+			if u != nil && u.Issuer == issuer {
+				return next(c)
+			}
+			if u != nil {
+				return core.NewError(http.StatusForbidden, 4130, "Sign in with single sign-on first")
+			}
+			if loginURL != "" && c.Request().Method == http.MethodGet {
+				return c.Redirect(http.StatusSeeOther, loginURL)
+			}
+			return core.NewError(http.StatusUnauthorized, 4110, "Sign in first")
+		}
+	}
+}
+
+// OwnerAsClient keys the rate limits of signed-in users on their account instead of their network.
+func OwnerAsClient(hash func(string) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if u := CurrentUser(c); u != nil {
+				c.Set("client", hash("user:"+u.ID))
+			}
+			return next(c)
+		}
+	}
+}
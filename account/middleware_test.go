@@ -0,0 +1,49 @@
+package account
+
+import (
+	"dataShare/core"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireIssuer(t *testing.T) {
+	issuer := "https://idp.example.com"
+	tests := []struct {
+		name     string
+		user     *User
+		method   string
+		expected int
+	}{
+		{"SSO user", &User{ID: "usr_1", Issuer: issuer, Subject: "1"}, http.MethodPost, http.StatusOK},
+		{"password user", &User{ID: "usr_2", PasswordHash: "hash"}, http.MethodPost, http.StatusForbidden},
+		{"other issuer", &User{ID: "usr_3", Issuer: "https://other.example.com", Subject: "1"}, http.MethodGet, http.StatusForbidden},
+		{"anonymous page", nil, http.MethodGet, http.StatusSeeOther},
+		{"anonymous upload", nil, http.MethodPost, http.StatusUnauthorized},
+	}
+	next := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(tt.method, "/", nil), rec)
+			if tt.user != nil {
+				c.Set("user", tt.user)
+			}
+			err := RequireIssuer(issuer, "/login")(next)(c)
+			status := rec.Code
+			var coreErr *core.Error
+			if errors.As(err, &coreErr) {
+				status = coreErr.Status
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.expected {
+				t.Fatalf("Expected status %d, but got %d", tt.expected, status)
+			}
+		})
+	}
+}
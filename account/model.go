@@ -0,0 +1,31 @@
+package account
+
+import (
+	"time"
+)
+
+type User struct {
+	ID           string    `gorm:"primaryKey;size:36" json:"id"`
+	Email        string    `gorm:"not null;uniqueIndex;size:255" json:"email"`
+	PasswordHash string    `gorm:"not null;size:60" json:"-"`
+	CreatedAt    time.Time `gorm:"not null" json:"created_at"`
+	// Issuer and Subject identify the user at the OpenID Connect provider, they are empty for password accounts.
+	Issuer  string `gorm:"not null;default:'';size:255;uniqueIndex:idx_users_identity,where:issuer <> ''" json:"-"`
+	Subject string `gorm:"not null;default:'';size:255;uniqueIndex:idx_users_identity,where:issuer <> ''" json:"-"`
+	// UploadsPerDay and BytesPerDay override the configured quotas when they are not zero.
+	UploadsPerDay int   `gorm:"not null;default:0" json:"uploads_per_day"`
+	BytesPerDay   int64 `gorm:"not null;default:0" json:"bytes_per_day"`
+}
+
+// APIKey authenticates API requests of a user. Only the hash of the key is stored,
+// Prefix is kept to tell the keys apart.
+type APIKey struct {
+	ID         string     `gorm:"primaryKey;size:36" json:"id"`
+	UserID     string     `gorm:"not null;index;size:36" json:"-"`
+	Name       string     `gorm:"not null;size:100" json:"name"`
+	Prefix     string     `gorm:"not null;size:16" json:"prefix"`
+	KeyHash    string     `gorm:"not null;uniqueIndex;size:65" json:"-"`
+	CreatedAt  time.Time  `gorm:"not null" json:"created_at"`
+	LastUsedAt *time.Time `gorm:"nullable" json:"last_used_at"`
+	RevokedAt  *time.Time `gorm:"nullable" json:"revoked_at"`
+}
@@ -0,0 +1,77 @@
+package account
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type RepositoryImp struct {
+	Db *gorm.DB
+}
+
+func NewRepositoryImp(db *gorm.DB) *RepositoryImp {
+	return &RepositoryImp{Db: db}
+}
+
+func (r *RepositoryImp) FindUserById(id string) (*User, error) {
+	var u User
+	err := r.Db.First(&u, "id = ?", id).Error
+	return &u, err
+}
+
+func (r *RepositoryImp) FindUserByEmail(email string) (*User, error) {
+	var u User
+	err := r.Db.First(&u, "email = ?", email).Error
+	return &u, err
+}
+
+// FindUserByIdentity returns the user linked to the subject of an OpenID Connect issuer.
+func (r *RepositoryImp) FindUserByIdentity(issuer, subject string) (*User, error) {
+	var u User
+	err := r.Db.First(&u, "issuer = ? AND subject = ?", issuer, subject).Error
+	return &u, err
+}
+
+// LinkUser links the user to the subject of an OpenID Connect issuer.
+func (r *RepositoryImp) LinkUser(u *User, issuer, subject string) error {
+	u.Issuer, u.Subject = issuer, subject
+	return r.Db.Model(u).Updates(map[string]interface{}{"issuer": issuer, "subject": subject}).Error
+}
+
+func (r *RepositoryImp) SaveUser(u *User) error {
+	return r.Db.Create(u).Error
+}
+
+func (r *RepositoryImp) FindAPIKeyByHash(hash string) (*APIKey, error) {
+	var k APIKey
+	err := r.Db.First(&k, "key_hash = ? AND revoked_at IS NULL", hash).Error
+	return &k, err
+}
+
+func (r *RepositoryImp) GetAPIKeys(userID string) ([]APIKey, error) {
+	var keys []APIKey
+	err := r.Db.Order("created_at DESC").Find(&keys, "user_id = ?", userID).Error
+	return keys, err
+}
+
+func (r *RepositoryImp) SaveAPIKey(k *APIKey) error {
+	return r.Db.Create(k).Error
+}
+
+func (r *RepositoryImp) TouchAPIKey(id string) error {
+	return r.Db.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", time.Now()).Error
+}
+
+// RevokeAPIKey revokes the key of the user. It returns gorm.ErrRecordNotFound if there is no such active key.
+func (r *RepositoryImp) RevokeAPIKey(userID, id string) error {
+	result := r.Db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package account
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SessionCookie = "datashare_session"
+
+// Sessions stores the signed-in user in an HMAC signed cookie, no server side state is kept.
+type Sessions struct {
+	secret []byte
+	ttl    time.Duration
+	secure bool
+}
+
+func NewSessions(secret []byte, ttl time.Duration, secure bool) *Sessions {
+	return &Sessions{secret: secret, ttl: ttl, secure: secure}
+}
+
+func (s *Sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Sessions) cookie(value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.secure,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Set signs userID in for the session duration.
+func (s *Sessions) Set(c echo.Context, userID string) {
+	expires := time.Now().Add(s.ttl)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userID + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	c.SetCookie(s.cookie(payload+"."+s.sign(payload), expires))
+}
+
+// Get returns the signed-in user ID, if the session cookie is valid and not expired.
+func (s *Sessions) Get(c echo.Context) (string, bool) {
+	cookie, err := c.Cookie(SessionCookie)
+	if err != nil {
+		return "", false
+	}
+	payload, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(s.sign(payload))) {
+		return "", false
+	}
+	content, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+	userID, expires, ok := strings.Cut(string(content), "|")
+	if !ok {
+		return "", false
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return "", false
+	}
+	return userID, true
+}
+
+func (s *Sessions) Clear(c echo.Context) {
+	c.SetCookie(s.cookie("", time.Unix(0, 0)))
+}
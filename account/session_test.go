@@ -0,0 +1,55 @@
+package account
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// roundTrip sets a session with s and reads it back with r from a new request carrying the cookie.
+func roundTrip(t *testing.T, s, r *Sessions, tamper func(*http.Cookie)) (string, bool) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	s.Set(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), "usr_123")
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly {
+		t.Fatalf("Expected one HttpOnly session cookie, but got %v", cookies)
+	}
+	if tamper != nil {
+		tamper(cookies[0])
+	}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(cookies[0])
+	return r.Get(e.NewContext(req, httptest.NewRecorder()))
+}
+
+func TestSessions(t *testing.T) {
+	s := NewSessions([]byte("secret"), time.Hour, false)
+
+	if userID, ok := roundTrip(t, s, s, nil); !ok || userID != "usr_123" {
+		t.Fatalf("Expected session of usr_123, but got '%s' (%v)", userID, ok)
+	}
+	if _, ok := roundTrip(t, s, NewSessions([]byte("other"), time.Hour, false), nil); ok {
+		t.Fatal("Expected session signed with another secret to be rejected")
+	}
+	if _, ok := roundTrip(t, s, s, func(c *http.Cookie) { c.Value = "x" + c.Value }); ok {
+		t.Fatal("Expected tampered session to be rejected")
+	}
+	expired := NewSessions([]byte("secret"), -time.Minute, false)
+	if _, ok := roundTrip(t, expired, expired, nil); ok {
+		t.Fatal("Expected expired session to be rejected")
+	}
+}
+
+func TestSessions_Clear(t *testing.T) {
+	s := NewSessions([]byte("secret"), time.Hour, true)
+	rec := httptest.NewRecorder()
+	s.Clear(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec))
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "" || !cookies[0].Secure {
+		t.Fatalf("Expected an empty secure cookie, but got %v", cookies)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"dataShare/account"
+	"dataShare/config"
+	"dataShare/document"
+	"dataShare/service"
+	"dataShare/sso"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+)
+
+// NewAccountHandler is a helper function that handles the creation of the account handler with required dependencies.
+func NewAccountHandler(c echo.Context) (*account.Handler, error) {
+	DB, ok := c.Get("db").(*gorm.DB)
+	if !ok {
+		return nil, errors.New("failed to get DB from context")
+	}
+	encryption, ok := c.Get("encryption").(*service.Encryption)
+	if !ok {
+		return nil, errors.New("failed to get encryption service from context")
+	}
+
+	return account.NewHandler(c, DB, encryption), nil
+}
+
+func renderLogin(c echo.Context, status int, errorMsg string) error {
+	cfg := c.Get("config").(*config.Config)
+	return c.Render(status, "login.html", map[string]interface{}{
+		"csrf":     c.Get("csrf"),
+		"signup":   cfg.Accounts.AllowSignup,
+		"oidc":     cfg.OIDC.Enabled,
+		"errorMsg": errorMsg,
+	})
+}
+
+func loginPage(c echo.Context) error {
+	if account.CurrentUser(c) != nil {
+		return c.Redirect(http.StatusSeeOther, "/account")
+	}
+	return renderLogin(c, http.StatusOK, "")
+}
+
+func loginHandler(sessions *account.Sessions) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h, err := NewAccountHandler(c)
+		if err != nil {
+			return renderLogin(c, http.StatusInternalServerError, "Something went wrong")
+		}
+		u, err := h.Login(c.FormValue("email"), c.FormValue("password"))
+		if err != nil {
+			return renderLogin(c, http.StatusUnauthorized, err.Error())
+		}
+		sessions.Set(c, u.ID)
+		return c.Redirect(http.StatusSeeOther, "/account")
+	}
+}
+
+func signupHandler(sessions *account.Sessions) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		h, err := NewAccountHandler(c)
+		if err != nil {
+			return renderLogin(c, http.StatusInternalServerError, "Something went wrong")
+		}
+		u, err := h.SignUp(c.FormValue("email"), c.FormValue("password"))
+		if err != nil {
+			return renderLogin(c, http.StatusUnprocessableEntity, err.Error())
+		}
+		sessions.Set(c, u.ID)
+		return c.Redirect(http.StatusSeeOther, "/account")
+	}
+}
+
+func logoutHandler(sessions *account.Sessions) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		sessions.Clear(c)
+		return c.Redirect(http.StatusSeeOther, "/")
+	}
+}
+
+// renderAccount shows the API keys and shares of the signed-in user. newKey is only shown once, right after creation.
+func renderAccount(c echo.Context, newKey string) error {
+	u := account.CurrentUser(c)
+	ah, err := NewAccountHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	dh, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	keys, err := ah.APIKeys(u)
+	if err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	shares, err := dh.Shares(u)
+	if err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	requests, err := dh.Requests(u)
+	if err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+
+	statuses := make(map[string]string, len(shares))
+	for _, d := range shares {
+		statuses[d.ID] = document.ShareStatus(&d)
+	}
+	requestStatuses := make(map[string]string, len(requests))
+	for i := range requests {
+		requestStatuses[requests[i].ID] = document.RequestStatusName(&requests[i])
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Render(http.StatusOK, "account.html", map[string]interface{}{
+		"csrf":            c.Get("csrf"),
+		"user":            u,
+		"keys":            keys,
+		"newKey":          newKey,
+		"shares":          shares,
+		"statuses":        statuses,
+		"requests":        requests,
+		"requestStatuses": requestStatuses,
+	})
+}
+
+func accountPage(c echo.Context) error {
+	return renderAccount(c, "")
+}
+
+func createAPIKey(c echo.Context) error {
+	h, err := NewAccountHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	key, _, err := h.CreateAPIKey(account.CurrentUser(c), c.FormValue("name"))
+	if err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	return renderAccount(c, key)
+}
+
+func revokeAPIKey(c echo.Context) error {
+	h, err := NewAccountHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	if err := h.RevokeAPIKey(account.CurrentUser(c), c.Param("id")); err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	return c.Redirect(http.StatusSeeOther, "/account")
+}
+
+// oidcCallback signs in the user returned by the identity provider, creating the account on first use.
+func oidcCallback(provider *sso.Provider, sessions *account.Sessions) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		identity, err := provider.Callback(c)
+		if errors.Is(err, sso.ErrDomain) || errors.Is(err, sso.ErrGroup) || errors.Is(err, sso.ErrNoEmail) {
+			return renderLogin(c, http.StatusForbidden, "Your account is not allowed to share documents")
+		}
+		if err != nil {
+			log.Printf("OIDC login failed: %s", err)
+			return renderLogin(c, http.StatusUnauthorized, "Sign in failed, please try again")
+		}
+		h, err := NewAccountHandler(c)
+		if err != nil {
+			return renderLogin(c, http.StatusInternalServerError, "Something went wrong")
+		}
+		u, err := h.ExternalLogin(identity.Issuer, identity.Subject, identity.Email)
+		if err != nil {
+			return renderLogin(c, http.StatusUnprocessableEntity, err.Error())
+		}
+		sessions.Set(c, u.ID)
+		return c.Redirect(http.StatusSeeOther, "/")
+	}
+}
+
+// registerAccountRoutes adds the sign in, sign up and account pages. With an OpenID Connect provider
+// the password login and sign up are replaced by the provider login.
+func registerAccountRoutes(e *echo.Echo, cfg *config.Config, sessions *account.Sessions, provider *sso.Provider, loginMiddlewares ...echo.MiddlewareFunc) {
+	e.GET("/login", loginPage)
+	if provider != nil {
+		e.GET("/auth/oidc/login", provider.Login, loginMiddlewares...)
+		e.GET("/auth/oidc/callback", oidcCallback(provider, sessions), loginMiddlewares...)
+	} else {
+		e.POST("/login", loginHandler(sessions), loginMiddlewares...)
+		if cfg.Accounts.AllowSignup {
+			e.POST("/signup", signupHandler(sessions), loginMiddlewares...)
+		}
+	}
+	e.POST("/logout", logoutHandler(sessions))
+
+	g := e.Group("/account", account.RequireUser("/login"))
+	g.GET("", accountPage)
+	g.POST("/keys", createAPIKey)
+	g.POST("/keys/:id/revoke", revokeAPIKey)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"dataShare/account"
+	"dataShare/config"
+	"dataShare/core"
+	"dataShare/document"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"time"
+)
+
+type uploadResponse struct {
+	ID         string   `json:"id"`
+	Key        string   `json:"key,omitempty"`
+	Shares     []string `json:"shares,omitempty"`
+	Link       string   `json:"link"`
+	ManageLink string   `json:"manage_link"`
+	SHA256     string   `json:"sha256"`
+}
+
+type shareResponse struct {
+	ID           string  `json:"id"`
+	Filename     string  `json:"filename"`
+	FileSize     int64   `json:"file_size"`
+	Status       string  `json:"status"`
+	UploadedAt   string  `json:"uploaded_at"`
+	DownloadedAt *string `json:"downloaded_at,omitempty"`
+	// Files is the number of files of the archive of the share, 0 for a single file.
+	Files           int `json:"files"`
+	FilesDownloaded int `json:"files_downloaded"`
+}
+
+// apiError writes err as JSON, using the status of *core.Error when possible.
+func apiError(c echo.Context, err error) error {
+	var coreErr *core.Error
+	if !errors.As(err, &coreErr) {
+		coreErr = core.NewError(http.StatusInternalServerError, 0, "Something went wrong")
+	}
+	return c.JSON(coreErr.Status, coreErr)
+}
+
+func apiUpload(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return apiError(c, core.NewError(http.StatusBadRequest, 1000, "Invalid multipart form"))
+	}
+	idKey, err := h.Encrypt(form)
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, newUploadResponse(c.Get("config").(*config.Config), idKey))
+}
+
+func newUploadResponse(cfg *config.Config, idKey *core.IDKey) uploadResponse {
+	return uploadResponse{
+		ID:         idKey.ID,
+		Key:        idKey.Key,
+		Shares:     idKey.Shares,
+		Link:       cfg.App.BaseURL + "/" + idKey.ID,
+		ManageLink: manageLink(cfg, idKey),
+		SHA256:     idKey.SHA256,
+	}
+}
+
+func apiShares(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	shares, err := h.Shares(account.CurrentUser(c))
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	response := make([]shareResponse, 0, len(shares))
+	for _, d := range shares {
+		share := shareResponse{
+			ID:              d.ID,
+			Filename:        d.Filename,
+			FileSize:        d.FileSize,
+			Status:          document.ShareStatus(&d),
+			Files:           d.Files,
+			FilesDownloaded: d.FilesDownloaded,
+			UploadedAt:      d.UploadedAt.Format(time.RFC3339),
+		}
+		if d.DownloadedAt != nil {
+			downloadedAt := d.DownloadedAt.Format(time.RFC3339)
+			share.DownloadedAt = &downloadedAt
+		}
+		response = append(response, share)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func apiCheck(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	ID := c.Param("id")
+	d, err := h.Check(ID)
+	if err != nil {
+		return apiError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":         ID,
+		"status":     document.StatusName(document.Ready),
+		"recipients": d.Format == document.FormatAge,
+		"exportable": d.Exportable(),
+		"threshold":  d.Threshold,
+		"passphrase": d.HasPassphrase,
+		"text":       d.IsText,
+		"files":      d.Files,
+	})
+}
+
+func apiDownload(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	export := c.FormValue("export") == "true"
+	if c.FormValue("manifest") == "true" {
+		listing, d, err := h.List(keyFromForm(c, c.Param("id"), c.FormValue("key")))
+		if err != nil {
+			return apiError(c, err)
+		}
+		if listing == nil {
+			return apiError(c, core.NewError(http.StatusUnprocessableEntity, 2160, "Can't read the list of files"))
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.JSON(http.StatusOK, map[string]interface{}{"id": d.ID, "sha256": listing.SHA256, "files": listing.Files})
+	}
+	dl, err := fetchDownload(c, h, c.Param("id"), export)
+	if err != nil {
+		return apiError(c, err)
+	}
+	defer dl.content.Close()
+
+	if dl.d.ShowsText(export) {
+		text, err := dl.content.Bytes()
+		if err != nil {
+			return apiError(c, err)
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		if err := c.JSON(http.StatusOK, map[string]string{"id": dl.d.ID, "text": string(text), "sha256": dl.content.SHA256}); err != nil {
+			return err
+		}
+		return h.Delivered(dl.d.ID, document.AllFiles, 0, int64(len(text)), int64(len(text)))
+	}
+
+	return sendDocument(c, h, dl)
+}
+
+// registerAPIRoutes adds the JSON API used by command line clients, authenticated with API keys.
+func registerAPIRoutes(e *echo.Echo, cfg *config.Config, uploadMiddlewares, checkMiddlewares, downloadMiddlewares []echo.MiddlewareFunc) {
+	g := e.Group("/api/v1")
+	g.POST("/documents", apiUpload, uploadMiddlewares...)
+	if cfg.Accounts.Enabled {
+		g.GET("/documents", apiShares, account.RequireUser(""))
+	}
+	g.GET("/documents/:id", apiCheck, checkMiddlewares...)
+	g.POST("/documents/:id", apiDownload, downloadMiddlewares...)
+	registerUploadRoutes(g, uploadMiddlewares)
+}
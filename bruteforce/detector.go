@@ -0,0 +1,146 @@
+package bruteforce
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config controls how the detector escalates. Failures are counted per client within Window:
+// from DelayAfter failures on every further failure is delayed, starting at BaseDelay and doubling
+// up to MaxDelay; at BanAfter failures the client is banned for BanDuration, doubled on every repeated
+// ban up to MaxBanDuration.
+type Config struct {
+	Window         time.Duration
+	DelayAfter     int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	BanAfter       int
+	BanDuration    time.Duration
+	MaxBanDuration time.Duration
+}
+
+type record struct {
+	failures     int
+	firstFailure time.Time
+	lastFailure  time.Time
+	bans         int
+	bannedUntil  time.Time
+}
+
+// Ban describes a banned client, as listed to admins.
+type Ban struct {
+	Client      string    `json:"client"`
+	Failures    int       `json:"failures"`
+	Bans        int       `json:"bans"`
+	LastFailure time.Time `json:"last_failure"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+// Detector tracks failed key attempts per client hash across all documents.
+type Detector struct {
+	cfg     Config
+	mu      sync.Mutex
+	clients map[string]*record
+	now     func() time.Time
+}
+
+func NewDetector(cfg Config) *Detector {
+	return &Detector{cfg: cfg, clients: make(map[string]*record), now: time.Now}
+}
+
+// BannedUntil returns the end of the ban of client, or the zero time if the client is not banned.
+func (d *Detector) BannedUntil(client string) time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.clients[client]
+	if !ok || !r.bannedUntil.After(d.now()) {
+		return time.Time{}
+	}
+	return r.bannedUntil
+}
+
+// Failure records a failed attempt and returns how long the response must be delayed.
+func (d *Detector) Failure(client string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	r, ok := d.clients[client]
+	if !ok {
+		r = &record{}
+		d.clients[client] = r
+	}
+	if now.Sub(r.firstFailure) > d.cfg.Window {
+		r.failures = 0
+		r.firstFailure = now
+	}
+	r.failures++
+	r.lastFailure = now
+
+	if r.failures >= d.cfg.BanAfter {
+		duration := d.cfg.BanDuration << r.bans
+		if duration > d.cfg.MaxBanDuration || duration <= 0 {
+			duration = d.cfg.MaxBanDuration
+		}
+		r.bans++
+		r.bannedUntil = now.Add(duration)
+		r.failures = 0
+		r.firstFailure = now
+	}
+
+	if r.failures < d.cfg.DelayAfter {
+		return 0
+	}
+	delay := d.cfg.BaseDelay << (r.failures - d.cfg.DelayAfter)
+	if delay > d.cfg.MaxDelay || delay <= 0 {
+		delay = d.cfg.MaxDelay
+	}
+	return delay
+}
+
+// Bans lists the clients currently banned, the latest bans first.
+func (d *Detector) Bans() []Ban {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	bans := []Ban{}
+	for client, r := range d.clients {
+		if r.bannedUntil.After(now) {
+			bans = append(bans, Ban{
+				Client:      client,
+				Failures:    r.failures,
+				Bans:        r.bans,
+				LastFailure: r.lastFailure,
+				BannedUntil: r.bannedUntil,
+			})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].BannedUntil.After(bans[j].BannedUntil) })
+	return bans
+}
+
+// Lift removes the ban and the failure history of client. It reports whether the client was banned.
+func (d *Detector) Lift(client string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r, ok := d.clients[client]
+	if !ok {
+		return false
+	}
+	delete(d.clients, client)
+	return r.bannedUntil.After(d.now())
+}
+
+// Prune forgets clients that are neither banned nor failed within the window.
+func (d *Detector) Prune() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	for client, r := range d.clients {
+		if !r.bannedUntil.After(now) && now.Sub(r.lastFailure) > d.cfg.Window {
+			delete(d.clients, client)
+		}
+	}
+}
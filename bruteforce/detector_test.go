@@ -0,0 +1,85 @@
+package bruteforce
+
+import (
+	"testing"
+	"time"
+)
+
+var testConfig = Config{
+	Window:         time.Hour,
+	DelayAfter:     2,
+	BaseDelay:      time.Second,
+	MaxDelay:       3 * time.Second,
+	BanAfter:       5,
+	BanDuration:    time.Hour,
+	MaxBanDuration: 3 * time.Hour,
+}
+
+func TestDetector_Escalation(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDetector(testConfig)
+	d.now = func() time.Time { return now }
+
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second}
+	for i, delay := range expected {
+		if got := d.Failure("client"); got != delay {
+			t.Fatalf("Failure %d: expected delay %s, but got %s", i+1, delay, got)
+		}
+	}
+	if !d.BannedUntil("client").IsZero() {
+		t.Fatal("Expected client not to be banned yet")
+	}
+
+	d.Failure("client")
+	if until := d.BannedUntil("client"); !until.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected ban until %s, but got %s", now.Add(time.Hour), until)
+	}
+	if !d.BannedUntil("other").IsZero() {
+		t.Fatal("Expected other clients not to be banned")
+	}
+	if bans := d.Bans(); len(bans) != 1 || bans[0].Client != "client" {
+		t.Fatalf("Expected one ban, but got %+v", bans)
+	}
+
+	// A repeated ban lasts twice as long, up to the maximum.
+	now = now.Add(2 * time.Hour)
+	if !d.BannedUntil("client").IsZero() {
+		t.Fatal("Expected ban to be over")
+	}
+	for i := 0; i < testConfig.BanAfter; i++ {
+		d.Failure("client")
+	}
+	if until := d.BannedUntil("client"); !until.Equal(now.Add(2 * time.Hour)) {
+		t.Fatalf("Expected ban until %s, but got %s", now.Add(2*time.Hour), until)
+	}
+
+	if !d.Lift("client") {
+		t.Fatal("Expected Lift to report the ban")
+	}
+	if !d.BannedUntil("client").IsZero() || len(d.Bans()) != 0 {
+		t.Fatal("Expected ban to be lifted")
+	}
+	if d.Lift("client") {
+		t.Fatal("Expected Lift of a client without ban to report false")
+	}
+}
+
+func TestDetector_Window(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := NewDetector(testConfig)
+	d.now = func() time.Time { return now }
+
+	for i := 0; i < testConfig.BanAfter-1; i++ {
+		d.Failure("client")
+	}
+	now = now.Add(2 * time.Hour)
+	if delay := d.Failure("client"); delay != 0 {
+		t.Fatalf("Expected failures to be forgotten after the window, but got delay %s", delay)
+	}
+
+	now = now.Add(2 * time.Hour)
+	d.Prune()
+	if len(d.clients) != 0 {
+		t.Fatal("Expected Prune to forget idle clients")
+	}
+}
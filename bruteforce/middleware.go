@@ -0,0 +1,33 @@
+package bruteforce
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// ContextDetector makes the detector available to handlers under the "bruteforce" key.
+func ContextDetector(d *Detector) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("bruteforce", d)
+			return next(c)
+		}
+	}
+}
+
+// ListBans is the admin endpoint listing the banned clients.
+func ListBans(d *Detector) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.JSON(http.StatusOK, d.Bans())
+	}
+}
+
+// LiftBan is the admin endpoint lifting the ban of the client hash given in the path.
+func LiftBan(d *Detector) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !d.Lift(c.Param("client")) {
+			return c.JSON(http.StatusNotFound, map[string]string{"msg": "Client is not banned"})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,140 @@
+package clientip
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExtractor_ExtractIP(t *testing.T) {
+	x, err := NewExtractor([]string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+
+	tests := []struct {
+		name     string
+		remote   string
+		xff      []string
+		realIP   string
+		expected string
+	}{
+		{"UntrustedIgnoresHeaders", "203.0.113.5:1234", []string{"1.1.1.1"}, "2.2.2.2", "203.0.113.5"},
+		{"TrustedNoHeaders", "10.1.1.1:1234", nil, "", "10.1.1.1"},
+		{"TrustedXFF", "10.1.1.1:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"TrustedXFFChain", "10.1.1.1:1234", []string{"6.6.6.6, 198.51.100.7, 10.2.2.2"}, "", "198.51.100.7"},
+		{"TrustedXFFMultipleHeaders", "10.1.1.1:1234", []string{"6.6.6.6", "198.51.100.7"}, "", "198.51.100.7"},
+		{"TrustedXFFAllTrusted", "10.1.1.1:1234", []string{"10.3.3.3, 10.2.2.2"}, "", "10.3.3.3"},
+		{"TrustedRealIP", "192.0.2.1:1234", nil, "198.51.100.8", "198.51.100.8"},
+		{"TrustedIPv6", "[fd00::1]:1234", []string{"2001:db8::1"}, "", "2001:db8::1"},
+		{"MappedIPv4", "[::ffff:10.1.1.1]:1234", []string{"198.51.100.7"}, "", "198.51.100.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := x.ExtractIP(req); got != tt.expected {
+				t.Fatalf("Expected '%s', but got '%s'", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewExtractor_Invalid(t *testing.T) {
+	if _, err := NewExtractor([]string{"not-a-cidr"}); err == nil {
+		t.Fatal("Expected error, but got nothing")
+	}
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		ip       string
+		expected string
+	}{
+		{"198.51.100.7", "198.51.100.7"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1:2::/64"},
+		{"2001:db8:1:2:ffff::1", "2001:db8:1:2::/64"},
+		{"invalid", "invalid"},
+	}
+	for _, tt := range tests {
+		if got := Group(tt.ip, 32, 64); got != tt.expected {
+			t.Fatalf("Group(%s): expected '%s', but got '%s'", tt.ip, tt.expected, got)
+		}
+	}
+	if got := Group("198.51.100.7", 24, 64); got != "198.51.100.0/24" {
+		t.Fatalf("Expected IPv4 grouping, but got '%s'", got)
+	}
+}
+
+func proxyV2Header(ip net.IP, port uint16) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, ip.To4()...)
+	header = append(header, 127, 0, 0, 1)
+	header = binary.BigEndian.AppendUint16(header, port)
+	header = binary.BigEndian.AppendUint16(header, 80)
+	return header
+}
+
+func TestProxyListener(t *testing.T) {
+	tests := []struct {
+		name     string
+		trusted  []string
+		header   []byte
+		expected string
+	}{
+		{"V1", []string{"127.0.0.1"}, []byte("PROXY TCP4 198.51.100.7 127.0.0.1 5555 80\r\n"), "198.51.100.7:5555"},
+		{"V1Unknown", []string{"127.0.0.1"}, []byte("PROXY UNKNOWN\r\n"), "127.0.0.1"},
+		{"V2", []string{"127.0.0.1"}, proxyV2Header(net.ParseIP("198.51.100.9"), 6666), "198.51.100.9:6666"},
+		{"NoHeader", []string{"127.0.0.1"}, nil, "127.0.0.1"},
+		{"Untrusted", []string{"10.0.0.0/8"}, nil, "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := NewExtractor(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			inner, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := NewProxyListener(inner, x, time.Second)
+			defer l.Close()
+
+			go func() {
+				conn, err := net.Dial("tcp", inner.Addr().String())
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write(append(tt.header, []byte("GET / HTTP/1.1\r\n")...))
+			}()
+
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			remote := conn.RemoteAddr().String()
+			if host, _, _ := net.SplitHostPort(remote); tt.expected != host && tt.expected != remote {
+				t.Fatalf("Expected remote '%s', but got '%s'", tt.expected, remote)
+			}
+			payload := make([]byte, len("GET / HTTP/1.1\r\n"))
+			if _, err := io.ReadFull(conn, payload); err != nil || string(payload) != "GET / HTTP/1.1\r\n" {
+				t.Fatalf("Expected payload after header, but got '%s' (%v)", payload, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Extractor resolves the client IP of a request, trusting forwarding headers only
+// when the request comes from one of the configured proxy networks.
+type Extractor struct {
+	trusted []netip.Prefix
+}
+
+func NewExtractor(trustedProxies []string) (*Extractor, error) {
+	x := &Extractor{}
+	for _, cidr := range trustedProxies {
+		prefix, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		x.trusted = append(x.trusted, prefix)
+	}
+	return x, nil
+}
+
+// parsePrefix accepts both CIDRs and single addresses.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Trusted reports whether addr belongs to a trusted proxy network.
+func (x *Extractor) Trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range x.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// ExtractIP implements echo.IPExtractor.
+// X-Forwarded-For is walked from right to left and the first address that is not a trusted
+// proxy is the client. X-Real-IP is used when X-Forwarded-For is missing.
+func (x *Extractor) ExtractIP(r *http.Request) string {
+	remote, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		return host
+	}
+	if !x.Trusted(remote) {
+		return remote.String()
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := remote
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseAddr(hops[i])
+			if !ok {
+				break
+			}
+			client = addr
+			if !x.Trusted(addr) {
+				break
+			}
+		}
+		return client.String()
+	}
+
+	if addr, ok := parseAddr(r.Header.Get("X-Real-IP")); ok {
+		return addr.String()
+	}
+	return remote.String()
+}
+
+// Group masks ip to its network prefix so that all addresses a single client can
+// easily rotate through (an IPv6 /64 for example) are rate-limited as one.
+func Group(ip string, ipv4Bits, ipv6Bits int) string {
+	addr, ok := parseAddr(ip)
+	if !ok {
+		return ip
+	}
+	bits := ipv6Bits
+	if addr.Is4() {
+		bits = ipv4Bits
+	}
+	if bits >= addr.BitLen() {
+		return addr.String()
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return addr.String()
+	}
+	return prefix.String()
+}
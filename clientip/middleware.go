@@ -0,0 +1,14 @@
+package clientip
+
+import "github.com/labstack/echo/v4"
+
+// ContextClient stores the hashed client network under the "client" context key.
+// The IP resolved by the echo IPExtractor is grouped with Group before hashing.
+func ContextClient(hash func(string) string, ipv4Bits, ipv6Bits int) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("client", hash(Group(c.RealIP(), ipv4Bits, ipv6Bits)))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package clientip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	proxyV1MaxLength = 107
+	proxyV1Prefix    = "PROXY "
+)
+
+// ProxyListener accepts PROXY protocol (v1 and v2) headers from trusted proxies and
+// reports the original client as the RemoteAddr of the connection.
+// Connections from untrusted peers are passed through untouched.
+type ProxyListener struct {
+	net.Listener
+	trusted func(netip.Addr) bool
+	timeout time.Duration
+}
+
+func NewProxyListener(inner net.Listener, x *Extractor, headerTimeout time.Duration) *ProxyListener {
+	return &ProxyListener{Listener: inner, trusted: x.Trusted, timeout: headerTimeout}
+}
+
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	remote, ok := parseAddr(conn.RemoteAddr().String())
+	if !ok || !l.trusted(remote) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyConn reads the PROXY header lazily, on the first Read or RemoteAddr call,
+// so a slow proxy never blocks the accept loop.
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.remote, c.err = readProxyHeader(c.reader)
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader consumes a PROXY header if present. It returns a nil address when there is
+// no header or the header carries no address (LOCAL or UNKNOWN).
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		// Too short to hold a header; the next Read reports what is left.
+		return nil, nil
+	}
+	switch {
+	case bytes.Equal(peek, proxyV2Signature):
+		return readProxyV2(r)
+	case bytes.HasPrefix(peek, []byte(proxyV1Prefix)):
+		return readProxyV1(r)
+	}
+	return nil, nil
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("proxy protocol: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("proxy protocol: header too long")
+	}
+
+	parts := strings.Fields(string(line))
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("proxy protocol: invalid header %q", strings.TrimSpace(string(line)))
+	}
+	addr, err := netip.ParseAddr(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: invalid source address: %w", err)
+	}
+	port, err := strconv.ParseUint(parts[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("proxy protocol: invalid source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("proxy protocol: %w", err)
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, fmt.Errorf("proxy protocol: unsupported version %d", version)
+	}
+	command, family := header[12]&0x0f, header[13]
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("proxy protocol: %w", err)
+	}
+
+	// LOCAL connections are health checks from the proxy itself.
+	if command == 0x0 {
+		return nil, nil
+	}
+	if command != 0x1 {
+		return nil, fmt.Errorf("proxy protocol: unsupported command %d", command)
+	}
+
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		if len(body) < 12 {
+			return nil, errors.New("proxy protocol: short IPv4 address block")
+		}
+		addr := netip.AddrFrom4([4]byte(body[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(body[8:10]))), nil
+	case 0x2: // AF_INET6
+		if len(body) < 36 {
+			return nil, errors.New("proxy protocol: short IPv6 address block")
+		}
+		addr := netip.AddrFrom16([16]byte(body[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(body[32:34]))), nil
+	}
+	return nil, nil
+}
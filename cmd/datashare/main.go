@@ -0,0 +1,748 @@
+// Command datashare uploads and downloads documents through the DataShare API.
+//
+//	datashare upload [flags] FILE|FOLDER...
+//	datashare upload -text [flags] < SECRET
+//	datashare upload -chunk SIZE [flags] FILE
+//	datashare download [flags] LINK|ID KEY
+//	datashare download [flags] LINK|ID SHARE SHARE...
+//	datashare download -i IDENTITY [flags] LINK|ID
+//	datashare download -export [flags] LINK|ID [KEY]
+//	datashare keygen [-o PATH]
+//
+// Documents uploaded with -r are encrypted to the public keys of their recipients, who decrypt
+// them with their private key, the identity created by keygen, instead of a shared key. Documents
+// uploaded with -age are stored as age files: -export downloads the encrypted file, which "age -d"
+// decrypts with the key, and decrypts it locally when the key is given. With -shares the key is
+// split into shares, enough of which must be given to download instead of the key. A -passphrase
+// chosen by the sender, DATASHARE_PASSPHRASE by default, is needed with the key to download. With
+// -text the secret text read from the standard input is sent instead of files, and download prints it.
+// The files of folders are sent with their paths, kept in the archive of the document, whose format
+// is chosen with -archive. With -chunk the file is sent in chunks through a resumable upload, so
+// that a broken connection only loses the current chunk. Interrupted downloads are resumed for as
+// long as the server allows it. -list prints the files of an archive with their sizes and SHA-256,
+// and -file downloads only the file at the listed index. Downloads are checked against the SHA-256
+// recorded at upload, printed by upload, when the server sends it, sealed to the recipients of
+// documents encrypted to them.
+//
+// The server and API key default to the DATASHARE_SERVER and DATASHARE_API_KEY environment variables.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dataShare/pow"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultServer = "http://localhost:1323"
+
+	tusVersion      = "1.0.0"
+	maxChunkRetries = 5
+)
+
+type client struct {
+	server     string
+	apiKey     string
+	passphrase string
+	http       *http.Client
+}
+
+type apiError struct {
+	Code   int    `json:"code"`
+	ErrMsg string `json:"msg"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.ErrMsg, e.Code)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  datashare upload [-server URL] [-api-key KEY] [-passphrase P] [-age] [-r PUBLIC_KEY]... [-shares N -threshold K] [-archive FORMAT] FILE|FOLDER...")
+	fmt.Fprintln(os.Stderr, "  datashare upload [-server URL] [-api-key KEY] [-passphrase P] -text < SECRET")
+	fmt.Fprintln(os.Stderr, "  datashare upload [-server URL] [-api-key KEY] [-passphrase P] [-age] [-r PUBLIC_KEY]... [-shares N -threshold K] -chunk SIZE FILE")
+	fmt.Fprintln(os.Stderr, "  datashare download [-server URL] [-o PATH] [-passphrase P] [-list | -file INDEX] LINK|ID KEY|SHARE...")
+	fmt.Fprintln(os.Stderr, "  datashare download [-server URL] [-o PATH] -i IDENTITY LINK|ID")
+	fmt.Fprintln(os.Stderr, "  datashare download [-server URL] [-o PATH] -export LINK|ID [KEY]")
+	fmt.Fprintln(os.Stderr, "  datashare keygen [-o PATH]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "upload":
+		err = upload(os.Args[2:])
+	case "download":
+		err = download(os.Args[2:])
+	case "keygen":
+		err = keygen(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "datashare:", err)
+		os.Exit(1)
+	}
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *client) {
+	c := &client{http: http.DefaultClient}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	server := os.Getenv("DATASHARE_SERVER")
+	if server == "" {
+		server = defaultServer
+	}
+	fs.StringVar(&c.server, "server", server, "DataShare server URL")
+	fs.StringVar(&c.apiKey, "api-key", os.Getenv("DATASHARE_API_KEY"), "API key")
+	fs.StringVar(&c.passphrase, "passphrase", os.Getenv("DATASHARE_PASSPHRASE"), "passphrase of the sender, needed with the key")
+	return fs, c
+}
+
+func (c *client) newRequest(method, endpoint string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimRight(c.server, "/")+endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	return req, nil
+}
+
+// solveChallenge sets the proof-of-work headers on req when the server requires them.
+func (c *client) solveChallenge(req *http.Request, scope string) error {
+	challengeReq, err := c.newRequest(http.MethodGet, "/pow/challenge?scope="+scope, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(challengeReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("can't get proof of work challenge: %s", resp.Status)
+	}
+	var challenge struct {
+		Challenge  string `json:"challenge"`
+		Difficulty int    `json:"difficulty"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&challenge); err != nil {
+		return err
+	}
+	req.Header.Set("X-PoW-Challenge", challenge.Challenge)
+	req.Header.Set("X-PoW-Solution", pow.Solve(challenge.Challenge, challenge.Difficulty))
+	return nil
+}
+
+func readError(resp *http.Response) error {
+	var e apiError
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.ErrMsg == "" {
+		return errors.New(resp.Status)
+	}
+	return &e
+}
+
+func upload(args []string) error {
+	fs, c := newFlagSet("upload")
+	var recipients []string
+	fs.Func("r", "public key (age1...) of a recipient, can be repeated", func(s string) error {
+		recipients = append(recipients, s)
+		return nil
+	})
+	ageFormat := fs.Bool("age", false, "store the document as a standard age file")
+	shares := fs.Int("shares", 0, "split the key into this number of shares")
+	threshold := fs.Int("threshold", 0, "number of shares needed to rebuild the key")
+	archive := fs.String("archive", "", "format of the archive of multiple files: zip, tar, tar.gz or tar.zst")
+	text := fs.Bool("text", false, "send the secret text read from the standard input instead of files")
+	chunk := fs.Int64("chunk", 0, "send a single FILE in chunks of this number of bytes, resuming after failed chunks")
+	fs.Parse(args)
+	if *text == (fs.NArg() > 0) || (*chunk > 0 && (*text || fs.NArg() != 1)) {
+		usage()
+	}
+
+	fields := url.Values{}
+	if c.passphrase != "" {
+		fields.Set("passphrase", c.passphrase)
+	}
+	if *ageFormat {
+		fields.Set("format", "age")
+	}
+	if *shares > 0 {
+		fields.Set("shares", strconv.Itoa(*shares))
+		fields.Set("threshold", strconv.Itoa(*threshold))
+	}
+	fields["recipients"] = recipients
+	if *archive != "" {
+		fields.Set("archive", *archive)
+	}
+
+	var resp *http.Response
+	var err error
+	if *chunk > 0 {
+		resp, err = c.resumableUpload(fs.Arg(0), *chunk, fields)
+	} else {
+		resp, err = c.formUpload(fields, func(form *multipart.Writer) error {
+			if *text {
+				return writeText(form, os.Stdin)
+			}
+			return writeFiles(form, fs.Args())
+		})
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return readError(resp)
+	}
+
+	var result struct {
+		Link       string   `json:"link"`
+		Key        string   `json:"key"`
+		Shares     []string `json:"shares"`
+		ManageLink string   `json:"manage_link"`
+		SHA256     string   `json:"sha256"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	fmt.Printf("Link:   %s\n", result.Link)
+	if result.Key != "" {
+		fmt.Printf("Key:    %s\n", result.Key)
+	}
+	for i, share := range result.Shares {
+		fmt.Printf("Share %d: %s\n", i+1, share)
+	}
+	fmt.Printf("Manage: %s\n", result.ManageLink)
+	if result.SHA256 != "" {
+		fmt.Printf("SHA-256: %s\n", result.SHA256)
+	}
+	return nil
+}
+
+// formUpload sends the fields and the content written by writeContent in a single multipart request.
+func (c *client) formUpload(fields url.Values, writeContent func(form *multipart.Writer) error) (*http.Response, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		for name, values := range fields {
+			for _, value := range values {
+				if err := form.WriteField(name, value); err != nil {
+					writer.CloseWithError(err)
+					return
+				}
+			}
+		}
+		writer.CloseWithError(writeContent(form))
+	}()
+
+	req, err := c.newRequest(http.MethodPost, "/api/v1/documents", body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if err := c.solveChallenge(req, "upload"); err != nil {
+		return nil, err
+	}
+	return c.http.Do(req)
+}
+
+// resumableUpload sends the file in chunks of chunkSize bytes with the tus protocol, resuming from the
+// offset known to the server after a failed chunk, then has the server encrypt it with the fields.
+func (c *client) resumableUpload(name string, chunkSize int64, fields url.Values) (*http.Response, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a file, only files can be sent in chunks", name)
+	}
+
+	req, err := c.newRequest(http.MethodPost, "/api/v1/uploads", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(info.Size(), 10))
+	req.Header.Set("Upload-Metadata", "filename "+base64.StdEncoding.EncodeToString([]byte(filepath.Base(name)))+
+		",filetype "+base64.StdEncoding.EncodeToString([]byte(mime.TypeByExtension(filepath.Ext(name)))))
+	if err := c.solveChallenge(req, "upload"); err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, readError(resp)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return nil, err
+	}
+	endpoint := location.Path
+
+	var offset int64
+	failures := 0
+	for offset < info.Size() {
+		next, err := c.sendChunk(endpoint, io.NewSectionReader(f, offset, min(chunkSize, info.Size()-offset)), offset)
+		if err != nil {
+			// Errors answered by the server won't go away by sending the chunk again.
+			var e *apiError
+			failures++
+			if errors.As(err, &e) || failures > maxChunkRetries {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "Chunk at byte %d failed, resuming: %s\n", offset, err)
+			time.Sleep(time.Duration(failures) * time.Second)
+			if next, err = c.uploadOffset(endpoint); err != nil {
+				continue
+			}
+		} else {
+			failures = 0
+		}
+		offset = next
+	}
+
+	req, err = c.newRequest(http.MethodPost, endpoint+"/document", strings.NewReader(fields.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.http.Do(req)
+}
+
+// sendChunk sends the chunk starting at offset and returns the offset the upload reached.
+func (c *client) sendChunk(endpoint string, chunk io.Reader, offset int64) (int64, error) {
+	req, err := c.newRequest(http.MethodPatch, endpoint, chunk)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return 0, readError(resp)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// uploadOffset asks the server how many bytes of the upload it received.
+func (c *client) uploadOffset(endpoint string) (int64, error) {
+	req, err := c.newRequest(http.MethodHead, endpoint, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.New(resp.Status)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// writeFiles writes the files, and the files of the folders with their paths in the folder.
+func writeFiles(form *multipart.Writer, names []string) error {
+	for _, name := range names {
+		root := filepath.Dir(filepath.Clean(name))
+		err := filepath.WalkDir(name, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if err := form.WriteField("paths", filepath.ToSlash(rel)); err != nil {
+				return err
+			}
+			return writeFile(form, p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return form.Close()
+}
+
+func writeFile(form *multipart.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	part, err := form.CreateFormFile("files", filepath.Base(name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, f)
+	return err
+}
+
+func writeText(form *multipart.Writer, r io.Reader) error {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if err := form.WriteField("text", string(text)); err != nil {
+		return err
+	}
+	return form.Close()
+}
+
+// documentID accepts a full link or a bare document ID.
+func documentID(s string) string {
+	if u, err := url.Parse(s); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return s
+}
+
+func download(args []string) error {
+	fs, c := newFlagSet("download")
+	output := fs.String("o", "", "output file, defaults to the name of the document")
+	identityFile := fs.String("i", "", "identity file to decrypt a document encrypted to recipients")
+	export := fs.Bool("export", false, "download the encrypted age file, decrypted locally when KEY is given")
+	list := fs.Bool("list", false, "print the files of the archive of the document")
+	file := fs.Int("file", -1, "download only the file of the archive at this index, as printed by -list")
+	fs.Parse(args)
+	var identities []age.Identity
+	form := url.Values{}
+	switch {
+	case *identityFile != "":
+		if fs.NArg() != 1 {
+			usage()
+		}
+		var err error
+		if identities, err = readIdentities(*identityFile); err != nil {
+			return err
+		}
+	case *export:
+		if fs.NArg() != 1 && fs.NArg() != 2 {
+			usage()
+		}
+		form.Set("export", "true")
+		if fs.NArg() == 2 {
+			identity, err := age.NewScryptIdentity(fs.Arg(1))
+			if err != nil {
+				return err
+			}
+			identities = []age.Identity{identity}
+		}
+	case fs.NArg() > 2:
+		form["shares"] = fs.Args()[1:]
+	default:
+		if fs.NArg() != 2 {
+			usage()
+		}
+		form.Set("key", fs.Arg(1))
+	}
+	if c.passphrase != "" {
+		form.Set("passphrase", c.passphrase)
+	}
+	if *file >= 0 {
+		form.Set("file", strconv.Itoa(*file))
+	}
+
+	endpoint := "/api/v1/documents/" + url.PathEscape(documentID(fs.Arg(0)))
+	if *list {
+		return c.listFiles(endpoint, form)
+	}
+	resp, err := c.fetchDocument(endpoint, form, 0)
+	if err != nil {
+		return err
+	}
+	body := &resumingBody{
+		body:  resp.Body,
+		fetch: func(offset int64) (*http.Response, error) { return c.fetchDocument(endpoint, form, offset) },
+	}
+	defer body.Close()
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+		var result struct {
+			Text   string `json:"text"`
+			SHA256 string `json:"sha256"`
+		}
+		if err := json.NewDecoder(body).Decode(&result); err != nil {
+			return err
+		}
+		sum := sha256.Sum256([]byte(result.Text))
+		if err := checkDigest(result.SHA256, sum[:]); err != nil {
+			return err
+		}
+		if *output == "" {
+			fmt.Print(result.Text)
+			return nil
+		}
+		if err := os.WriteFile(*output, []byte(result.Text), 0600); err != nil {
+			return err
+		}
+		fmt.Println("Saved", *output)
+		return nil
+	}
+
+	name := *output
+	if name == "" {
+		_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		if err != nil || params["filename"] == "" {
+			return errors.New("server did not send a file name, use -o")
+		}
+		name = filepath.Base(params["filename"])
+		if identities != nil {
+			name = strings.TrimSuffix(name, ".age")
+		}
+	}
+	// Exported documents come without a digest, the server can't decrypt it without the key. The digest
+	// of documents encrypted to recipients is sealed to them.
+	expected := digestHeader(resp.Header)
+	if expected == "" && identities != nil {
+		if expected, err = sealedDigest(resp.Header, identities); err != nil {
+			return err
+		}
+	}
+	var content io.Reader = body
+	if identities != nil {
+		if content, err = age.Decrypt(body, identities...); err != nil {
+			return fmt.Errorf("can't decrypt document: %w", err)
+		}
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := checkDigest(expected, hash.Sum(nil)); err != nil {
+		os.Remove(name)
+		return err
+	}
+	fmt.Println("Saved", name)
+	return nil
+}
+
+// digestHeader returns the hex SHA-256 of the downloaded content sent by the server in a Repr-Digest
+// or Digest header, or "" if it sent none.
+func digestHeader(header http.Header) string {
+	for _, field := range []string{header.Get("Repr-Digest"), header.Get("Digest")} {
+		for _, digest := range strings.Split(field, ",") {
+			algorithm, value, found := strings.Cut(strings.TrimSpace(digest), "=")
+			if !found || !strings.EqualFold(algorithm, "sha-256") {
+				continue
+			}
+			if sum, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":")); err == nil {
+				return hex.EncodeToString(sum)
+			}
+		}
+	}
+	return ""
+}
+
+// sealedDigest returns the hex SHA-256 the server sent in an X-Sealed-Digest header, decrypted with
+// identities, or "" if it sent none.
+func sealedDigest(header http.Header, identities []age.Identity) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(header.Get("X-Sealed-Digest"))
+	if err != nil || len(sealed) == 0 {
+		return "", nil
+	}
+	r, err := age.Decrypt(bytes.NewReader(sealed), identities...)
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt the SHA-256 of the document: %w", err)
+	}
+	sum, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("can't decrypt the SHA-256 of the document: %w", err)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+// checkDigest compares the SHA-256 of the downloaded content with the hex one recorded at upload, if any.
+func checkDigest(expected string, sum []byte) error {
+	if expected == "" {
+		return nil
+	}
+	if !strings.EqualFold(expected, hex.EncodeToString(sum)) {
+		return fmt.Errorf("SHA-256 of the download %x doesn't match %s sent by the server", sum, expected)
+	}
+	fmt.Fprintln(os.Stderr, "SHA-256 verified:", expected)
+	return nil
+}
+
+// listFiles prints the index, size, SHA-256 and name of each file of the archive of the document.
+func (c *client) listFiles(endpoint string, form url.Values) error {
+	form.Set("manifest", "true")
+	resp, err := c.fetchDocument(endpoint, form, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Files []struct {
+			Name   string `json:"name"`
+			Size   int64  `json:"size"`
+			SHA256 string `json:"sha256"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	for i, f := range result.Files {
+		fmt.Printf("%d\t%d\t%s\t%s\n", i, f.Size, f.SHA256, f.Name)
+	}
+	return nil
+}
+
+// fetchDocument downloads the document from offset, which the server must send as a byte range.
+func (c *client) fetchDocument(endpoint string, form url.Values, offset int64) (*http.Response, error) {
+	req, err := c.newRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	expected := http.StatusOK
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		expected = http.StatusPartialContent
+	}
+	if err := c.solveChallenge(req, "download"); err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != expected {
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return nil, errors.New("server can't resume the download")
+		}
+		return nil, readError(resp)
+	}
+	return resp, nil
+}
+
+// resumingBody reads a downloaded document. When the connection breaks, it fetches the rest of the
+// document from where it stopped, while the server still allows it.
+type resumingBody struct {
+	body    io.ReadCloser
+	fetch   func(offset int64) (*http.Response, error)
+	offset  int64
+	retries int
+}
+
+func (r *resumingBody) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	for n == 0 && err != nil && err != io.EOF && r.retries < maxChunkRetries {
+		r.retries++
+		fmt.Fprintf(os.Stderr, "Download interrupted at byte %d, resuming: %s\n", r.offset, err)
+		time.Sleep(time.Duration(r.retries) * time.Second)
+		r.body.Close()
+		var resp *http.Response
+		var e *apiError
+		if resp, err = r.fetch(r.offset); errors.As(err, &e) {
+			return 0, err
+		} else if err != nil {
+			continue
+		}
+		r.body = resp.Body
+		n, err = r.body.Read(p)
+		r.offset += int64(n)
+	}
+	if n > 0 {
+		r.retries = 0
+		if err != io.EOF {
+			err = nil
+		}
+	}
+	return n, err
+}
+
+func (r *resumingBody) Close() error {
+	return r.body.Close()
+}
+
+func readIdentities(name string) ([]age.Identity, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("can't read identity file %s: %w", name, err)
+	}
+	return identities, nil
+}
+
+// keygen creates a new identity, in the age key file format, and prints its public key.
+func keygen(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	output := fs.String("o", "", "identity file, printed when empty")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		usage()
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s\n", time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+	if *output == "" {
+		fmt.Print(content)
+		return nil
+	}
+	if err := os.WriteFile(*output, []byte(content), 0600); err != nil {
+		return err
+	}
+	fmt.Println("Public key:", identity.Recipient())
+	return nil
+}
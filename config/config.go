@@ -0,0 +1,299 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Config is the effective configuration of the application.
+// Every field is resolved in this order, later sources taking precedence:
+// `default` tag, YAML file, environment (including an optional .env file) and command line flags.
+type Config struct {
+	App          App          `yaml:"app"`
+	Database     Database     `yaml:"database"`
+	Encryption   Encryption   `yaml:"encryption"`
+	Health       Health       `yaml:"health"`
+	TLS          TLS          `yaml:"tls"`
+	ClientIP     ClientIP     `yaml:"client_ip"`
+	RateLimit    RateLimit    `yaml:"rate_limit"`
+	BruteForce   BruteForce   `yaml:"brute_force"`
+	Admin        Admin        `yaml:"admin"`
+	PoW          PoW          `yaml:"pow"`
+	Privacy      Privacy      `yaml:"privacy"`
+	Accounts     Accounts     `yaml:"accounts"`
+	OIDC         OIDC         `yaml:"oidc"`
+	Keys         Keys         `yaml:"keys"`
+	Downloads    Downloads    `yaml:"downloads"`
+	Archive      Archive      `yaml:"archive"`
+	Scan         Scan         `yaml:"scan"`
+	ContentTypes ContentTypes `yaml:"content_types"`
+}
+
+type App struct {
+	Host    string `yaml:"host" env:"APP_HOST" flag:"host" default:"localhost" usage:"address the server listens on"`
+	Port    int    `yaml:"port" env:"APP_PORT" flag:"port" default:"1323" usage:"port the server listens on"`
+	BaseURL string `yaml:"base_url" env:"BASE_URL" flag:"base-url" default:"http://localhost:1323" usage:"public URL used to build document links"`
+}
+
+type Database struct {
+	Host     string `yaml:"host" env:"DB_HOST" flag:"db-host" default:"localhost" usage:"database host"`
+	Port     int    `yaml:"port" env:"DB_PORT" flag:"db-port" default:"5432" usage:"database port"`
+	Name     string `yaml:"name" env:"DB_NAME" flag:"db-name" usage:"database name"`
+	User     string `yaml:"user" env:"DB_USER" flag:"db-user" default:"postgres" usage:"database user"`
+	Password string `yaml:"password" env:"DB_PASSWORD" flag:"db-password" secret:"true" usage:"database password"`
+}
+
+type Encryption struct {
+	Iterations int    `yaml:"iterations" env:"ENCRYPTION_ITERATIONS" flag:"encryption-iterations" default:"600000" usage:"PBKDF2 iterations"`
+	BlockSize  int    `yaml:"block_size" env:"ENCRYPTION_BLOCK_SIZE_LENGTH" flag:"encryption-block-size" default:"32" usage:"AES key size in bytes (16, 24 or 32)"`
+	SaltLength int    `yaml:"salt_length" env:"ENCRYPTION_SALT_LENGTH" flag:"encryption-salt-length" default:"16" usage:"PBKDF2 salt length in bytes"`
+	HashSalt   string `yaml:"hash_salt" env:"ENCRYPTION_HASH_SALT" flag:"encryption-hash-salt" secret:"true" usage:"salt used to hash client identifiers"`
+}
+
+type Health struct {
+	Timeout       time.Duration `yaml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" default:"5s" usage:"timeout of the readiness checks"`
+	MinFreeSpace  uint64        `yaml:"min_free_space" env:"HEALTH_MIN_FREE_SPACE" flag:"health-min-free-space" default:"104857600" usage:"minimum free bytes in the storage folder"`
+	MaxCleanupAge time.Duration `yaml:"max_cleanup_age" env:"HEALTH_MAX_CLEANUP_AGE" flag:"health-max-cleanup-age" default:"5m" usage:"maximum time since the last cleanup run"`
+}
+
+// TLS is enabled when both CertFile and KeyFile are set.
+type TLS struct {
+	CertFile              string        `yaml:"cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" usage:"PEM certificate file, enables HTTPS"`
+	KeyFile               string        `yaml:"key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" usage:"PEM private key file"`
+	ReloadInterval        time.Duration `yaml:"reload_interval" env:"TLS_RELOAD_INTERVAL" flag:"tls-reload-interval" default:"30s" usage:"how often the certificate files are checked for changes"`
+	RedirectPort          int           `yaml:"redirect_port" env:"TLS_REDIRECT_PORT" flag:"tls-redirect-port" usage:"plain HTTP port redirecting to HTTPS, 0 disables it"`
+	HSTSMaxAge            time.Duration `yaml:"hsts_max_age" env:"TLS_HSTS_MAX_AGE" flag:"tls-hsts-max-age" usage:"Strict-Transport-Security max-age, 0 disables it"`
+	HSTSIncludeSubdomains bool          `yaml:"hsts_include_subdomains" env:"TLS_HSTS_INCLUDE_SUBDOMAINS" flag:"tls-hsts-include-subdomains" usage:"add includeSubDomains to Strict-Transport-Security"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+type ClientIP struct {
+	TrustedProxies     []string      `yaml:"trusted_proxies" env:"CLIENT_IP_TRUSTED_PROXIES" flag:"trusted-proxies" usage:"comma separated CIDRs of proxies allowed to set the client IP"`
+	ProxyProtocol      bool          `yaml:"proxy_protocol" env:"CLIENT_IP_PROXY_PROTOCOL" flag:"proxy-protocol" usage:"accept PROXY protocol headers from trusted proxies"`
+	ProxyHeaderTimeout time.Duration `yaml:"proxy_header_timeout" env:"CLIENT_IP_PROXY_HEADER_TIMEOUT" flag:"proxy-header-timeout" default:"5s" usage:"time allowed to read a PROXY protocol header"`
+	IPv4PrefixLength   int           `yaml:"ipv4_prefix_length" env:"CLIENT_IP_IPV4_PREFIX_LENGTH" flag:"ipv4-prefix-length" default:"32" usage:"IPv4 prefix length clients are grouped by"`
+	IPv6PrefixLength   int           `yaml:"ipv6_prefix_length" env:"CLIENT_IP_IPV6_PREFIX_LENGTH" flag:"ipv6-prefix-length" default:"64" usage:"IPv6 prefix length clients are grouped by"`
+}
+
+// RateLimit configures one token bucket per route and client. Each limit is the bucket capacity,
+// refilled completely over its period.
+type RateLimit struct {
+	Store             string        `yaml:"store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" default:"memory" usage:"where buckets are kept: memory or postgres"`
+	Uploads           int           `yaml:"uploads" env:"RATE_LIMIT_UPLOADS" flag:"rate-limit-uploads" default:"5" usage:"uploads allowed per period"`
+	UploadsPeriod     time.Duration `yaml:"uploads_period" env:"RATE_LIMIT_UPLOADS_PERIOD" flag:"rate-limit-uploads-period" default:"1h"`
+	UploadBytes       int64         `yaml:"upload_bytes" env:"RATE_LIMIT_UPLOAD_BYTES" flag:"rate-limit-upload-bytes" default:"524288000" usage:"bytes allowed to be uploaded per period"`
+	UploadBytesPeriod time.Duration `yaml:"upload_bytes_period" env:"RATE_LIMIT_UPLOAD_BYTES_PERIOD" flag:"rate-limit-upload-bytes-period" default:"1h"`
+	Downloads         int           `yaml:"downloads" env:"RATE_LIMIT_DOWNLOADS" flag:"rate-limit-downloads" default:"10" usage:"download attempts (key submissions) allowed per period"`
+	DownloadsPeriod   time.Duration `yaml:"downloads_period" env:"RATE_LIMIT_DOWNLOADS_PERIOD" flag:"rate-limit-downloads-period" default:"10m"`
+	Checks            int           `yaml:"checks" env:"RATE_LIMIT_CHECKS" flag:"rate-limit-checks" default:"30" usage:"document lookups allowed per period"`
+	ChecksPeriod      time.Duration `yaml:"checks_period" env:"RATE_LIMIT_CHECKS_PERIOD" flag:"rate-limit-checks-period" default:"1m"`
+	Logins            int           `yaml:"logins" env:"RATE_LIMIT_LOGINS" flag:"rate-limit-logins" default:"10" usage:"sign in and sign up attempts allowed per period"`
+	LoginsPeriod      time.Duration `yaml:"logins_period" env:"RATE_LIMIT_LOGINS_PERIOD" flag:"rate-limit-logins-period" default:"10m"`
+	Challenges        int           `yaml:"challenges" env:"RATE_LIMIT_CHALLENGES" flag:"rate-limit-challenges" default:"30" usage:"proof of work challenges issued per period"`
+	ChallengesPeriod  time.Duration `yaml:"challenges_period" env:"RATE_LIMIT_CHALLENGES_PERIOD" flag:"rate-limit-challenges-period" default:"10m"`
+}
+
+// BruteForce configures the detection of key guessing across documents, see bruteforce.Config.
+type BruteForce struct {
+	Window         time.Duration `yaml:"window" env:"BRUTE_FORCE_WINDOW" flag:"brute-force-window" default:"1h" usage:"period failed attempts are counted over"`
+	DelayAfter     int           `yaml:"delay_after" env:"BRUTE_FORCE_DELAY_AFTER" flag:"brute-force-delay-after" default:"3" usage:"failed attempts before responses are delayed"`
+	BaseDelay      time.Duration `yaml:"base_delay" env:"BRUTE_FORCE_BASE_DELAY" flag:"brute-force-base-delay" default:"1s" usage:"first delay, doubled on every further failure"`
+	MaxDelay       time.Duration `yaml:"max_delay" env:"BRUTE_FORCE_MAX_DELAY" flag:"brute-force-max-delay" default:"10s"`
+	BanAfter       int           `yaml:"ban_after" env:"BRUTE_FORCE_BAN_AFTER" flag:"brute-force-ban-after" default:"10" usage:"failed attempts before the client is banned"`
+	BanDuration    time.Duration `yaml:"ban_duration" env:"BRUTE_FORCE_BAN_DURATION" flag:"brute-force-ban-duration" default:"1h" usage:"first ban duration, doubled on every further ban"`
+	MaxBanDuration time.Duration `yaml:"max_ban_duration" env:"BRUTE_FORCE_MAX_BAN_DURATION" flag:"brute-force-max-ban-duration" default:"24h"`
+}
+
+// Admin endpoints are disabled unless Token is set.
+type Admin struct {
+	Token string `yaml:"token" env:"ADMIN_TOKEN" flag:"admin-token" secret:"true" usage:"bearer token of the /admin endpoints"`
+}
+
+// PoW configures the proof-of-work challenge required before uploads and key submissions.
+// Difficulty is in leading zero bits of SHA-256; every extra bit doubles the expected work.
+type PoW struct {
+	Enabled       bool          `yaml:"enabled" env:"POW_ENABLED" flag:"pow" usage:"require a proof of work before uploads and key submissions"`
+	Secret        string        `yaml:"secret" env:"POW_SECRET" flag:"pow-secret" secret:"true" usage:"HMAC key signing the challenges, random when empty (single instance only)"`
+	TTL           time.Duration `yaml:"ttl" env:"POW_TTL" flag:"pow-ttl" default:"5m" usage:"validity of a challenge"`
+	Difficulty    int           `yaml:"difficulty" env:"POW_DIFFICULTY" flag:"pow-difficulty" default:"16" usage:"difficulty of a client far from its rate limits"`
+	MaxDifficulty int           `yaml:"max_difficulty" env:"POW_MAX_DIFFICULTY" flag:"pow-max-difficulty" default:"22" usage:"difficulty of a client at its rate limits"`
+}
+
+// Privacy mode hides which document IDs exist: every document that is not Ready answers like an
+// unknown ID, and lookups take at least MinResponseTime so timing does not tell them apart.
+type Privacy struct {
+	UniformResponses bool          `yaml:"uniform_responses" env:"PRIVACY_UNIFORM_RESPONSES" flag:"privacy" usage:"answer the same for unknown, downloaded, expired and locked documents"`
+	MinResponseTime  time.Duration `yaml:"min_response_time" env:"PRIVACY_MIN_RESPONSE_TIME" flag:"privacy-min-response-time" default:"500ms" usage:"minimum duration of document lookups in privacy mode"`
+}
+
+// Accounts configures optional sender accounts. Quotas apply per account and can be overridden per user.
+type Accounts struct {
+	Enabled               bool          `yaml:"enabled" env:"ACCOUNTS_ENABLED" flag:"accounts" usage:"enable user accounts and API keys"`
+	AllowSignup           bool          `yaml:"allow_signup" env:"ACCOUNTS_ALLOW_SIGNUP" flag:"accounts-allow-signup" default:"true" usage:"let anyone create an account"`
+	AllowAnonymousUploads bool          `yaml:"allow_anonymous_uploads" env:"ACCOUNTS_ALLOW_ANONYMOUS_UPLOADS" flag:"accounts-allow-anonymous-uploads" default:"true" usage:"accept uploads without an account"`
+	SessionSecret         string        `yaml:"session_secret" env:"ACCOUNTS_SESSION_SECRET" flag:"accounts-session-secret" secret:"true" usage:"HMAC key signing session cookies, random when empty (single instance only)"`
+	SessionTTL            time.Duration `yaml:"session_ttl" env:"ACCOUNTS_SESSION_TTL" flag:"accounts-session-ttl" default:"168h"`
+	UploadsPerDay         int           `yaml:"uploads_per_day" env:"ACCOUNTS_UPLOADS_PER_DAY" flag:"accounts-uploads-per-day" default:"50" usage:"default number of uploads per account per day"`
+	BytesPerDay           int64         `yaml:"bytes_per_day" env:"ACCOUNTS_BYTES_PER_DAY" flag:"accounts-bytes-per-day" default:"2147483648" usage:"default bytes uploaded per account per day"`
+}
+
+// OIDC replaces the password login of senders with an OpenID Connect provider. Uploading then
+// requires a signed in account; download links stay public.
+type OIDC struct {
+	Enabled        bool     `yaml:"enabled" env:"OIDC_ENABLED" flag:"oidc" usage:"sign senders in with an OpenID Connect provider"`
+	IssuerURL      string   `yaml:"issuer_url" env:"OIDC_ISSUER_URL" flag:"oidc-issuer-url" usage:"issuer URL used for discovery"`
+	ClientID       string   `yaml:"client_id" env:"OIDC_CLIENT_ID" flag:"oidc-client-id"`
+	ClientSecret   string   `yaml:"client_secret" env:"OIDC_CLIENT_SECRET" flag:"oidc-client-secret" secret:"true" usage:"client secret, empty for public clients"`
+	Scopes         []string `yaml:"scopes" env:"OIDC_SCOPES" flag:"oidc-scopes" default:"email,profile" usage:"comma separated scopes requested besides openid"`
+	AllowedDomains []string `yaml:"allowed_domains" env:"OIDC_ALLOWED_DOMAINS" flag:"oidc-allowed-domains" usage:"comma separated email domains allowed to sign in, any when empty"`
+	AllowedGroups  []string `yaml:"allowed_groups" env:"OIDC_ALLOWED_GROUPS" flag:"oidc-allowed-groups" usage:"comma separated groups allowed to sign in, any when empty"`
+	GroupsClaim    string   `yaml:"groups_claim" env:"OIDC_GROUPS_CLAIM" flag:"oidc-groups-claim" default:"groups" usage:"ID token claim listing the groups of the user"`
+}
+
+// Keys configures the keys generated for documents: 12 characters, or words of the EFF wordlist that are
+// easier to read aloud and type on phones.
+type Keys struct {
+	Format string `yaml:"format" env:"KEYS_FORMAT" flag:"keys-format" default:"characters" usage:"format of generated keys: characters or words"`
+	Words  int    `yaml:"words" env:"KEYS_WORDS" flag:"keys-words" default:"6" usage:"number of words of word keys"`
+}
+
+// Downloads configures how long a download can be resumed, with byte ranges, after the key was first entered.
+// The document is deleted once every byte was delivered or when the window closes. Resumed downloads with
+// the same key spend neither the download rate limit nor a proof of work.
+type Downloads struct {
+	ResumeWindow time.Duration `yaml:"resume_window" env:"DOWNLOADS_RESUME_WINDOW" flag:"downloads-resume-window" default:"15m" usage:"how long an interrupted download can be resumed"`
+}
+
+// Archive configures the archives multiple uploaded files are put in. Senders can choose another format than Format.
+type Archive struct {
+	Format           string `yaml:"format" env:"ARCHIVE_FORMAT" flag:"archive-format" default:"zip" usage:"default format of archives: zip, tar, tar.gz or tar.zst"`
+	Name             string `yaml:"name" env:"ARCHIVE_NAME" flag:"archive-name" default:"archive" usage:"file name of archives, without extension"`
+	CompressionLevel int    `yaml:"compression_level" env:"ARCHIVE_COMPRESSION_LEVEL" flag:"archive-compression-level" default:"-1" usage:"compression level of archives, from 0 (none) to 9 (best), -1 for the default"`
+}
+
+// Scan sends the content of uploads to a clamd daemon, infected uploads are rejected. It is enabled when
+// ClamdAddress is set, uploads are then rejected too when clamd can't scan them.
+type Scan struct {
+	ClamdAddress string        `yaml:"clamd_address" env:"SCAN_CLAMD_ADDRESS" flag:"scan-clamd-address" usage:"clamd address, tcp://host:port or unix:///path, scanning is disabled when empty"`
+	Timeout      time.Duration `yaml:"timeout" env:"SCAN_TIMEOUT" flag:"scan-timeout" default:"2m" usage:"maximum duration of the scan of an upload"`
+}
+
+func (s Scan) Enabled() bool {
+	return s.ClamdAddress != ""
+}
+
+// ContentTypes decides which uploaded files are accepted by the type sniffed from their first bytes.
+// Types are media types such as "application/pdf", or every type of a kind such as "image/*".
+type ContentTypes struct {
+	Allow          []string `yaml:"allow" env:"CONTENT_TYPES_ALLOW" flag:"content-types-allow" usage:"comma separated types accepted, any when empty"`
+	Deny           []string `yaml:"deny" env:"CONTENT_TYPES_DENY" flag:"content-types-deny" default:"application/x-msdownload,application/x-executable,application/x-mach-binary" usage:"comma separated types rejected, also when claimed by the client"`
+	RejectMismatch bool     `yaml:"reject_mismatch" env:"CONTENT_TYPES_REJECT_MISMATCH" flag:"content-types-reject-mismatch" default:"true" usage:"reject files whose content doesn't match the type claimed by the client"`
+}
+
+const minSaltLength = 16
+
+// Validate checks the configuration and returns every problem found, not only the first one.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.App.Port > 0 && c.App.Port <= 65535, "app.port", "must be between 1 and 65535, got %d", c.App.Port)
+	check(c.App.BaseURL != "", "app.base_url", "is required")
+
+	check(c.Database.Host != "", "database.host", "is required")
+	check(c.Database.Port > 0 && c.Database.Port <= 65535, "database.port", "must be between 1 and 65535, got %d", c.Database.Port)
+	check(c.Database.Name != "", "database.name", "is required")
+	check(c.Database.User != "", "database.user", "is required")
+
+	check(c.Encryption.Iterations > 0, "encryption.iterations", "must be positive, got %d", c.Encryption.Iterations)
+	bs := c.Encryption.BlockSize
+	check(bs == 16 || bs == 24 || bs == 32, "encryption.block_size", "must be 16, 24 or 32, got %d", bs)
+	check(c.Encryption.SaltLength >= minSaltLength, "encryption.salt_length", "must be at least %d, got %d", minSaltLength, c.Encryption.SaltLength)
+	check(c.Encryption.HashSalt != "", "encryption.hash_salt", "is required")
+
+	check(c.Health.Timeout > 0, "health.timeout", "must be positive, got %s", c.Health.Timeout)
+	check(c.Health.MaxCleanupAge > 0, "health.max_cleanup_age", "must be positive, got %s", c.Health.MaxCleanupAge)
+
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "tls", "cert_file and key_file must be set together")
+	check(c.TLS.ReloadInterval > 0, "tls.reload_interval", "must be positive, got %s", c.TLS.ReloadInterval)
+	check(c.TLS.RedirectPort >= 0 && c.TLS.RedirectPort <= 65535, "tls.redirect_port", "must be between 0 and 65535, got %d", c.TLS.RedirectPort)
+	check(c.TLS.RedirectPort == 0 || c.TLS.Enabled(), "tls.redirect_port", "requires cert_file and key_file")
+	check(c.TLS.RedirectPort == 0 || c.TLS.RedirectPort != c.App.Port, "tls.redirect_port", "must differ from app.port")
+	check(c.TLS.HSTSMaxAge >= 0, "tls.hsts_max_age", "must not be negative, got %s", c.TLS.HSTSMaxAge)
+
+	for _, cidr := range c.ClientIP.TrustedProxies {
+		_, errCIDR := netip.ParsePrefix(cidr)
+		_, errAddr := netip.ParseAddr(cidr)
+		check(errCIDR == nil || errAddr == nil, "client_ip.trusted_proxies", "%q is not a CIDR or IP address", cidr)
+	}
+	check(!c.ClientIP.ProxyProtocol || len(c.ClientIP.TrustedProxies) > 0, "client_ip.proxy_protocol", "requires trusted_proxies")
+	check(c.ClientIP.IPv4PrefixLength >= 8 && c.ClientIP.IPv4PrefixLength <= 32, "client_ip.ipv4_prefix_length", "must be between 8 and 32, got %d", c.ClientIP.IPv4PrefixLength)
+	check(c.ClientIP.IPv6PrefixLength >= 16 && c.ClientIP.IPv6PrefixLength <= 128, "client_ip.ipv6_prefix_length", "must be between 16 and 128, got %d", c.ClientIP.IPv6PrefixLength)
+
+	rl := c.RateLimit
+	check(rl.Store == "memory" || rl.Store == "postgres", "rate_limit.store", "must be memory or postgres, got %q", rl.Store)
+	check(rl.Uploads > 0 && rl.UploadsPeriod > 0, "rate_limit.uploads", "limit and period must be positive")
+	check(rl.UploadBytes > 0 && rl.UploadBytesPeriod > 0, "rate_limit.upload_bytes", "limit and period must be positive")
+	check(rl.Downloads > 0 && rl.DownloadsPeriod > 0, "rate_limit.downloads", "limit and period must be positive")
+	check(rl.Checks > 0 && rl.ChecksPeriod > 0, "rate_limit.checks", "limit and period must be positive")
+	check(rl.Logins > 0 && rl.LoginsPeriod > 0, "rate_limit.logins", "limit and period must be positive")
+	check(rl.Challenges > 0 && rl.ChallengesPeriod > 0, "rate_limit.challenges", "limit and period must be positive")
+
+	bf := c.BruteForce
+	check(bf.Window > 0, "brute_force.window", "must be positive, got %s", bf.Window)
+	check(bf.DelayAfter > 0 && bf.DelayAfter <= bf.BanAfter, "brute_force.delay_after", "must be between 1 and ban_after, got %d", bf.DelayAfter)
+	check(bf.BaseDelay > 0 && bf.BaseDelay <= bf.MaxDelay, "brute_force.base_delay", "must be positive and not above max_delay")
+	check(bf.BanDuration > 0 && bf.BanDuration <= bf.MaxBanDuration, "brute_force.ban_duration", "must be positive and not above max_ban_duration")
+	check(c.Admin.Token == "" || len(c.Admin.Token) >= 32, "admin.token", "must be at least 32 characters long")
+
+	check(c.PoW.TTL > 0, "pow.ttl", "must be positive, got %s", c.PoW.TTL)
+	check(c.PoW.Difficulty >= 1 && c.PoW.Difficulty <= c.PoW.MaxDifficulty, "pow.difficulty", "must be between 1 and max_difficulty, got %d", c.PoW.Difficulty)
+	check(c.PoW.MaxDifficulty <= 32, "pow.max_difficulty", "must be at most 32, got %d", c.PoW.MaxDifficulty)
+
+	check(c.Privacy.MinResponseTime >= 0, "privacy.min_response_time", "must not be negative, got %s", c.Privacy.MinResponseTime)
+
+	check(c.Accounts.Enabled || c.Accounts.AllowAnonymousUploads, "accounts.allow_anonymous_uploads", "can only be disabled with accounts enabled")
+	check(c.Accounts.SessionTTL > 0, "accounts.session_ttl", "must be positive, got %s", c.Accounts.SessionTTL)
+	check(c.Accounts.UploadsPerDay > 0, "accounts.uploads_per_day", "must be positive, got %d", c.Accounts.UploadsPerDay)
+	check(c.Accounts.BytesPerDay > 0, "accounts.bytes_per_day", "must be positive, got %d", c.Accounts.BytesPerDay)
+
+	check(c.Keys.Format == "characters" || c.Keys.Format == "words", "keys.format", "must be characters or words, got %q", c.Keys.Format)
+	check(c.Keys.Words >= 6 && c.Keys.Words <= 20, "keys.words", "must be between 6 and 20, got %d", c.Keys.Words)
+
+	a := c.Archive
+	check(a.Format == "zip" || a.Format == "tar" || a.Format == "tar.gz" || a.Format == "tar.zst", "archive.format", "must be zip, tar, tar.gz or tar.zst, got %q", a.Format)
+	check(a.Name != "" && len(a.Name) <= 200 && !strings.ContainsAny(a.Name, `/\`), "archive.name", "must be between 1 and 200 characters without slashes, got %q", a.Name)
+	check(a.CompressionLevel >= -1 && a.CompressionLevel <= 9, "archive.compression_level", "must be between -1 and 9, got %d", a.CompressionLevel)
+
+	if c.Scan.Enabled() {
+		network, address, _ := strings.Cut(c.Scan.ClamdAddress, "://")
+		check((network == "tcp" || network == "unix") && address != "", "scan.clamd_address", "must be tcp://host:port or unix:///path, got %q", c.Scan.ClamdAddress)
+		check(c.Scan.Timeout > 0, "scan.timeout", "must be positive, got %s", c.Scan.Timeout)
+	}
+
+	for _, t := range append(c.ContentTypes.Allow, c.ContentTypes.Deny...) {
+		kind, subtype, found := strings.Cut(t, "/")
+		check(found && kind != "" && kind != "*" && subtype != "" && !strings.Contains(subtype, "/"), "content_types", "types must be type/subtype or type/*, got %q", t)
+	}
+
+	check(c.Downloads.ResumeWindow > 0 && c.Downloads.ResumeWindow <= 24*time.Hour, "downloads.resume_window", "must be positive and at most 24h, got %s", c.Downloads.ResumeWindow)
+
+	if c.OIDC.Enabled {
+		check(c.Accounts.Enabled, "oidc.enabled", "requires accounts.enabled")
+		check(c.OIDC.IssuerURL != "", "oidc.issuer_url", "is required")
+		check(c.OIDC.ClientID != "", "oidc.client_id", "is required")
+		check(len(c.OIDC.AllowedGroups) == 0 || c.OIDC.GroupsClaim != "", "oidc.groups_claim", "is required with allowed groups")
+	}
+
+	return errors.Join(errs...)
+}
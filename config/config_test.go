@@ -0,0 +1,106 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("can't write config file: %s", err)
+	}
+	return path
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+app:
+  port: 2000
+  host: 0.0.0.0
+database:
+  name: fromfile
+encryption:
+  hash_salt: fromfilesalt
+health:
+  timeout: 2s
+`)
+	t.Setenv("APP_PORT", "3000")
+	t.Setenv("DB_NAME", "fromenv")
+
+	cfg, err := Load([]string{"-config", path, "-db-name", "fromflag"})
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	if cfg.App.Host != "0.0.0.0" {
+		t.Fatalf("Expected host from file, but got '%s'", cfg.App.Host)
+	}
+	if cfg.App.Port != 3000 {
+		t.Fatalf("Expected port from env, but got %d", cfg.App.Port)
+	}
+	if cfg.Database.Name != "fromflag" {
+		t.Fatalf("Expected database name from flag, but got '%s'", cfg.Database.Name)
+	}
+	if cfg.Encryption.BlockSize != 32 {
+		t.Fatalf("Expected default block size, but got %d", cfg.Encryption.BlockSize)
+	}
+	if cfg.Health.Timeout != 2*time.Second {
+		t.Fatalf("Expected timeout from file, but got %s", cfg.Health.Timeout)
+	}
+}
+
+func TestLoad_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		err  string
+	}{
+		{"BlockSize", []string{"-encryption-block-size", "20"}, "encryption.block_size: must be 16, 24 or 32, got 20"},
+		{"SaltLength", []string{"-encryption-salt-length", "8"}, "encryption.salt_length: must be at least 16, got 8"},
+		{"Port", []string{"-port", "0"}, "app.port: must be between 1 and 65535, got 0"},
+		{"NotANumber", []string{"-port", "abc"}, "app.port: invalid value of -port"},
+		{"UnknownFlag", []string{"-nope"}, "invalid flags"},
+		{"KeyWords", []string{"-keys-format", "words", "-keys-words", "4"}, "keys.words: must be between 6 and 20, got 4"},
+		{"ArchiveFormat", []string{"-archive-format", "rar"}, `archive.format: must be zip, tar, tar.gz or tar.zst, got "rar"`},
+		{"ClamdAddress", []string{"-scan-clamd-address", "localhost:3310"}, `scan.clamd_address: must be tcp://host:port or unix:///path, got "localhost:3310"`},
+		{"ContentType", []string{"-content-types-allow", "image/*,pdf"}, `content_types: types must be type/subtype or type/*, got "pdf"`},
+		{"ResumeWindow", []string{"-downloads-resume-window", "48h"}, "downloads.resume_window: must be positive and at most 24h, got 48h0m0s"},
+		{"OIDCWithoutAccounts", []string{"-oidc", "true", "-oidc-issuer-url", "https://idp.example.com", "-oidc-client-id", "datashare"}, "oidc.enabled: requires accounts.enabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-db-name", "db", "-encryption-hash-salt", "salt"}, tt.args...)
+			_, err := Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Expected error containing '%s', but got '%v'", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg, err := Load([]string{"-db-name", "db", "-db-password", "dbsecret", "-encryption-hash-salt", "saltsecret"})
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cfg.Redacted().Write(&buf); err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "dbsecret") || strings.Contains(out, "saltsecret") {
+		t.Fatalf("Expected secrets to be redacted, but got:\n%s", out)
+	}
+	if cfg.Database.Password != "dbsecret" {
+		t.Fatal("Redacted must not modify the original configuration")
+	}
+	if !strings.Contains(out, "max_cleanup_age: 5m0s") {
+		t.Fatalf("Expected durations printed as text, but got:\n%s", out)
+	}
+}
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	configFileEnv  = "CONFIG_FILE"
+	configFileFlag = "config"
+	redacted       = "********"
+)
+
+// field is a leaf of the Config struct together with the metadata of its tags.
+type field struct {
+	path  string
+	value reflect.Value
+	tag   reflect.StructTag
+}
+
+func fields(v reflect.Value, prefix string) []field {
+	var result []field
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		path := prefix + name
+		fv := v.Field(i)
+		if sf.Type.Kind() == reflect.Struct && sf.Type != reflect.TypeOf(time.Duration(0)) {
+			result = append(result, fields(fv, path+".")...)
+			continue
+		}
+		result = append(result, field{path: path, value: fv, tag: sf.Tag})
+	}
+	return result
+}
+
+func setValue(v reflect.Value, raw string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// Load builds the configuration from defaults, the YAML file given by -config or CONFIG_FILE,
+// the environment and the command line flags in args. A missing .env file is not an error.
+func Load(args []string) (*Config, error) {
+	cfg := &Config{}
+	all := fields(reflect.ValueOf(cfg).Elem(), "")
+
+	for _, f := range all {
+		if def, ok := f.tag.Lookup("default"); ok {
+			if err := setValue(f.value, def); err != nil {
+				return nil, fmt.Errorf("%s: invalid default %q: %w", f.path, def, err)
+			}
+		}
+	}
+
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("can't load .env file: %w", err)
+	}
+
+	flags, configFile, err := parseFlags(all, args)
+	if err != nil {
+		return nil, err
+	}
+	if configFile == "" {
+		configFile = os.Getenv(configFileEnv)
+	}
+	if configFile != "" {
+		if err := loadFile(cfg, configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range all {
+		name := f.tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if raw, ok := os.LookupEnv(name); ok {
+			if err := setValue(f.value, raw); err != nil {
+				return nil, fmt.Errorf("%s: invalid value of %s: %w", f.path, name, err)
+			}
+		}
+	}
+
+	for _, f := range all {
+		if raw, ok := flags[f.tag.Get("flag")]; ok {
+			if err := setValue(f.value, raw); err != nil {
+				return nil, fmt.Errorf("%s: invalid value of -%s: %w", f.path, f.tag.Get("flag"), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFlags returns the raw value of every flag explicitly set in args and the config file path.
+func parseFlags(all []field, args []string) (map[string]string, string, error) {
+	set := make(map[string]string)
+	flagSet := flag.NewFlagSet("dataShare", flag.ContinueOnError)
+	flagSet.SetOutput(io.Discard)
+	configFile := flagSet.String(configFileFlag, "", "path to a YAML configuration file")
+	for _, f := range all {
+		name := f.tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		flagSet.Func(name, f.tag.Get("usage"), func(raw string) error {
+			set[name] = raw
+			return nil
+		})
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return nil, "", fmt.Errorf("invalid flags: %w", err)
+	}
+	return set, *configFile, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("can't parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration with every field tagged as secret masked.
+func (c *Config) Redacted() *Config {
+	cp := *c
+	for _, f := range fields(reflect.ValueOf(&cp).Elem(), "") {
+		if f.tag.Get("secret") == "true" && f.value.Kind() == reflect.String && f.value.String() != "" {
+			f.value.SetString(redacted)
+		}
+	}
+	return &cp
+}
+
+// toNode converts a struct into a YAML mapping, keeping the field order and printing durations as text.
+func toNode(v reflect.Value) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := &yaml.Node{Kind: yaml.ScalarNode, Value: strings.Split(sf.Tag.Get("yaml"), ",")[0]}
+		fv := v.Field(i)
+		var value *yaml.Node
+		switch {
+		case sf.Type == reflect.TypeOf(time.Duration(0)):
+			value = &yaml.Node{Kind: yaml.ScalarNode, Value: time.Duration(fv.Int()).String()}
+		case sf.Type.Kind() == reflect.Struct:
+			value = toNode(fv)
+		default:
+			value = &yaml.Node{}
+			if err := value.Encode(fv.Interface()); err != nil {
+				value = &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(fv.Interface())}
+			}
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node
+}
+
+// Write prints the configuration as YAML.
+func (c *Config) Write(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(toNode(reflect.ValueOf(c).Elem())); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
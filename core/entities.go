@@ -20,9 +20,17 @@ func NewError(status int, code int, message string) *Error {
 	}
 }
 
+// IDKey identifies a document and the key to decrypt it. When the key is split, Shares
+// holds the key shares instead of Key. Passphrase is the optional second factor chosen by the sender,
+// it is never sent back. SHA256 is the hex SHA-256 of the uploaded content, for the sender to
+// share with the recipient.
 type IDKey struct {
-	ID  string `json:"id"`
-	Key string `json:"key"`
+	ID          string   `json:"id"`
+	Key         string   `json:"key"`
+	Shares      []string `json:"shares,omitempty"`
+	Passphrase  string   `json:"-"`
+	ManageToken string   `json:"manage_token,omitempty"`
+	SHA256      string   `json:"sha256,omitempty"`
 }
 
 func NewIDKey(ID string, key string) *IDKey {
@@ -1,21 +1,43 @@
 package document
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"dataShare/account"
+	"dataShare/bruteforce"
+	"dataShare/config"
 	"dataShare/core"
+	"dataShare/ratelimit"
+	"dataShare/scan"
 	"dataShare/service"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filippo.io/age"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 	"io"
+	"log"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	dataFolder          = "./datafiles/"
-	maxUploadFileSize   = 100 << 20 // 100 MiB
-	totalUploadsPerHour = 5
+	DataFolder        = "./datafiles/"
+	MaxUploadFileSize = 100 << 20 // 100 MiB
+
+	// defaultResumeWindow is the resume window without configuration.
+	defaultResumeWindow = 15 * time.Minute
+
+	minPassphraseLength = 8
+	maxPassphraseLength = 256
+	maxTextSize         = 64 << 10 // 64 KiB
 )
 
 type Handler struct {
@@ -28,6 +50,78 @@ func NewHandler(c echo.Context, db *gorm.DB, e *service.Encryption) *Handler {
 	return &Handler{c: c, DB: db, e: e}
 }
 
+// client returns the hashed client identifier set by the clientip middleware,
+// falling back to the hash of the request IP.
+func (h *Handler) client() string {
+	if client, ok := h.c.Get("client").(string); ok {
+		return client
+	}
+	return h.e.HashString(h.c.RealIP())
+}
+
+// checkBan rejects clients banned by the brute-force detector.
+func (h *Handler) checkBan() *core.Error {
+	d, ok := h.c.Get("bruteforce").(*bruteforce.Detector)
+	if !ok {
+		return nil
+	}
+	until := d.BannedUntil(h.client())
+	if until.IsZero() {
+		return nil
+	}
+	h.c.Response().Header().Set("Retry-After", strconv.Itoa(int(time.Until(until).Seconds())+1))
+	return core.NewError(http.StatusTooManyRequests, 2110, "Too many failed attempts, try again later")
+}
+
+// recordFailure registers a failed attempt with the brute-force detector
+// and waits the delay it imposes before the response is sent.
+func (h *Handler) recordFailure() {
+	d, ok := h.c.Get("bruteforce").(*bruteforce.Detector)
+	if !ok {
+		return
+	}
+	delay := d.Failure(h.client())
+	if delay <= 0 {
+		return
+	}
+	select {
+	case <-time.After(delay):
+	case <-h.c.Request().Context().Done():
+	}
+}
+
+// checkOwner returns the signed-in sender, checking that anonymous uploads are allowed when there is
+// none and that the upload of size bytes fits in the daily quotas of the sender.
+func (h *Handler) checkOwner(size int) (*account.User, *core.Error) {
+	cfg, _ := h.c.Get("config").(*config.Config)
+	u := account.CurrentUser(h.c)
+	if u == nil {
+		if cfg != nil && !cfg.Accounts.AllowAnonymousUploads {
+			return nil, core.NewError(http.StatusUnauthorized, 1070, "Sign in to upload files")
+		}
+		return nil, nil
+	}
+	if cfg == nil {
+		return u, nil
+	}
+
+	uploadsPerDay, bytesPerDay := int64(cfg.Accounts.UploadsPerDay), cfg.Accounts.BytesPerDay
+	if u.UploadsPerDay > 0 {
+		uploadsPerDay = int64(u.UploadsPerDay)
+	}
+	if u.BytesPerDay > 0 {
+		bytesPerDay = u.BytesPerDay
+	}
+	total, bytes, err := NewRepositoryImp(h.DB).GetOwnerUsage(u.ID, time.Now().Add(-24*time.Hour))
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	if total >= uploadsPerDay || bytes+int64(size) > bytesPerDay {
+		return nil, core.NewError(http.StatusTooManyRequests, 1080, "Daily upload quota exceeded")
+	}
+	return u, nil
+}
+
 // getTotalFileSize calculates the total file size of multiple multipart.FileHeaders.
 func getTotalFileSize(files []*multipart.FileHeader) int {
 	totalFileSize := 0
@@ -40,7 +134,7 @@ func getTotalFileSize(files []*multipart.FileHeader) int {
 func (h *Handler) validateFiles(form *multipart.Form) ([]*multipart.FileHeader, *core.Error) {
 
 	files := form.File["files"]
-	if totalFileSize := getTotalFileSize(files); totalFileSize > maxUploadFileSize {
+	if totalFileSize := getTotalFileSize(files); totalFileSize > MaxUploadFileSize {
 		return nil, core.NewError(http.StatusBadRequest, 1005, "File size is too large")
 	}
 	if len(files) == 0 {
@@ -49,131 +143,787 @@ func (h *Handler) validateFiles(form *multipart.Form) ([]*multipart.FileHeader,
 	return files, nil
 }
 
-func (h *Handler) Encrypt(form *multipart.Form) (*core.IDKey, error) {
+// parseRecipients returns the public keys of the optional "recipients" form field, one per line
+// or one per value. Without recipients the document is encrypted with a generated passphrase.
+func parseRecipients(form *multipart.Form) ([]age.Recipient, *core.Error) {
+	text := strings.Join(form.Value["recipients"], "\n")
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+	recipients, err := service.ParseRecipients(text)
+	if err != nil {
+		return nil, core.NewError(http.StatusBadRequest, 1090, "Invalid recipients: "+err.Error())
+	}
+	return recipients, nil
+}
 
-	files, e := h.validateFiles(form)
-	if e != nil {
-		return nil, e
+// parseShares returns the optional number of key shares and threshold from the "shares" and "threshold" form fields.
+func parseShares(form *multipart.Form) (int, int, *core.Error) {
+	if len(form.Value["shares"]) == 0 || form.Value["shares"][0] == "" {
+		return 0, 0, nil
+	}
+	invalid := core.NewError(http.StatusBadRequest, 1100, "Key shares must be between 2 and 10, with a threshold between 2 and the number of shares")
+	shares, err := strconv.Atoi(form.Value["shares"][0])
+	if err != nil || len(form.Value["threshold"]) == 0 {
+		return 0, 0, invalid
 	}
+	threshold, err := strconv.Atoi(form.Value["threshold"][0])
+	if err != nil || threshold < 2 || threshold > shares || shares > service.MaxShares {
+		return 0, 0, invalid
+	}
+	return shares, threshold, nil
+}
 
-	file, err := service.GetFileFromFileHeader(files)
-	if err != nil {
-		return nil, core.NewError(http.StatusBadRequest, 1020, "Can't get file from header")
+// newKey generates the key of a new document in the configured format.
+func (h *Handler) newKey() (string, int, error) {
+	cfg, ok := h.c.Get("config").(*config.Config)
+	if !ok || cfg.Keys.Format != "words" {
+		return service.NewKey(), KeyCharacters, nil
 	}
+	key, err := service.NewWordKey(cfg.Keys.Words)
+	return key, KeyWords, err
+}
 
-	ipAddr := h.c.RealIP()
-	client := h.e.HashString(ipAddr)
-	dr := NewRepositoryImp(h.DB)
-	total, err := dr.GetTotalUsage(client)
-	if err != nil {
-		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+// parsePassphrase returns the optional passphrase chosen by the sender, needed with the key to decrypt the document.
+func parsePassphrase(form *multipart.Form) (string, *core.Error) {
+	if len(form.Value["passphrase"]) == 0 || form.Value["passphrase"][0] == "" {
+		return "", nil
 	}
-	if total >= totalUploadsPerHour {
-		return nil, core.NewError(http.StatusUnprocessableEntity, 1040, "Allowed number of uploads per hour exceeded")
+	passphrase := form.Value["passphrase"][0]
+	if len(passphrase) < minPassphraseLength || len(passphrase) > maxPassphraseLength {
+		return "", core.NewError(http.StatusBadRequest, 1110, "Passphrase must be between 8 and 256 characters")
 	}
+	return passphrase, nil
+}
 
-	document := Document{
+// checkUploadBytes consumes size bytes of the upload bytes rate limit of the client.
+func (h *Handler) checkUploadBytes(size int) *core.Error {
+	l, ok := h.c.Get("ratelimiter").(*ratelimit.Limiter)
+	if !ok {
+		return nil
+	}
+	err := l.Check(h.c, ratelimit.PolicyUploadBytes, float64(size))
+	var coreErr *core.Error
+	if errors.As(err, &coreErr) {
+		return coreErr
+	}
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	return nil
+}
+
+func (h *Handler) newDocument(file *service.File) Document {
+	return Document{
 		ID:              service.NewID("doc"),
 		Filename:        file.Name,
 		FileSize:        file.Size,
 		FileContentType: file.ContentType,
 		Status:          Ready,
 		UploadedAt:      time.Now(),
-		Client:          client,
+		Client:          h.client(),
+	}
+}
+
+// contentCheck checks the uploaded files against the content types of the configuration: their sniffed
+// and claimed types must not be denied, the sniffed one must be allowed and match the claimed one.
+func (h *Handler) contentCheck() service.ContentCheck {
+	cfg, ok := h.c.Get("config").(*config.Config)
+	if !ok {
+		return nil
 	}
+	policy := cfg.ContentTypes
+	return func(name, claimed, sniffed string) error {
+		denied := service.MatchContentType(sniffed, policy.Deny) || (claimed != "" && service.MatchContentType(claimed, policy.Deny))
+		if denied || (len(policy.Allow) > 0 && !service.MatchContentType(sniffed, policy.Allow)) {
+			return core.NewError(http.StatusUnsupportedMediaType, 1210, fmt.Sprintf("Files of type %s are not allowed: %s", sniffed, name))
+		}
+		if policy.RejectMismatch && !service.CompatibleContentTypes(claimed, sniffed) {
+			return core.NewError(http.StatusUnsupportedMediaType, 1220, fmt.Sprintf("Content of %s is %s, not %s", name, sniffed, service.MediaType(claimed)))
+		}
+		return nil
+	}
+}
+
+// encrypter returns a writer encrypting to w, closed once the whole content was written.
+type encrypter func(w io.Writer) (io.WriteCloser, error)
+
+// metadataSealer encrypts the metadata of a document for those who can decrypt its content.
+type metadataSealer interface {
+	Seal(content []byte) ([]byte, error)
+}
+
+// recipientsSealer encrypts metadata to the recipients of a document, the server can't decrypt them.
+type recipientsSealer []age.Recipient
+
+func (r recipientsSealer) Seal(content []byte) ([]byte, error) {
+	return service.EncryptToRecipients(r, content)
+}
+
+// store saves the document and its content encrypted by encrypt, with its metadata sealed by sealer
+// unless it is nil. before runs first in the same transaction, an error from it cancels the upload.
+// The content is streamed from the upload to the stored file, the size of the document is only known
+// once it is written.
+func (h *Handler) store(document *Document, file *service.File, encrypt encrypter, sealer metadataSealer, before func(tx *gorm.DB) error) error {
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		if before != nil {
+			if err := before(tx); err != nil {
+				return err
+			}
+		}
+
+		targetPath := DataFolder + document.ID
+		if err := h.writeEncrypted(targetPath, document, file, encrypt); err != nil {
+			os.Remove(targetPath)
+			return err
+		}
+		document.FileSize = file.Size
+		document.FileContentType = file.ContentType
+		if err := sealMetadata(document, file, sealer); err != nil {
+			os.Remove(targetPath)
+			return err
+		}
 
-	passphrase := service.NewKey()
-	err = h.DB.Transaction(func(tx *gorm.DB) error {
 		dr := NewRepositoryImp(tx)
-		err = dr.Save(&document)
-		if err != nil {
+		if err := dr.Save(document); err != nil {
+			os.Remove(targetPath)
 			return err
 		}
+		return nil
+	})
+}
 
-		documentContent, err := h.e.Encrypt(passphrase, file.Content)
+// writeEncrypted writes the content of file encrypted to targetPath. When a scanner is configured the
+// content is staged and scanned first, and only encrypted once clamd found it clean.
+func (h *Handler) writeEncrypted(targetPath string, document *Document, file *service.File, encrypt encrypter) error {
+	write := file.WriteTo
+	if scanner, ok := h.c.Get("scanner").(*scan.Client); ok {
+		staged, err := h.stageScanned(scanner, document, file)
 		if err != nil {
 			return err
 		}
-		targetPath := dataFolder + document.ID
-		targetFile, err := os.Create(targetPath)
-		defer targetFile.Close()
+		defer os.Remove(staged.Name())
+		defer staged.Close()
+		write = func(w io.Writer) (int64, error) {
+			return io.Copy(w, staged)
+		}
+	}
+
+	targetFile, err := os.OpenFile(targetPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer targetFile.Close()
+	w, err := encrypt(targetFile)
+	if err != nil {
+		return err
+	}
+	if _, err := write(w); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return targetFile.Close()
+}
+
+// stageScanned writes the plaintext of file to a staging file while it is sent to clamd, and returns
+// it rewound once the content is clean. The staging file is removed when the content is rejected,
+// otherwise the caller removes it; one left behind by a crash is removed by the cleanup of staging files.
+func (h *Handler) stageScanned(scanner *scan.Client, document *Document, file *service.File) (_ *os.File, err error) {
+	if err := os.MkdirAll(StagingFolder, 0700); err != nil {
+		return nil, err
+	}
+	staged, err := os.CreateTemp(StagingFolder, "scan-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
 		if err != nil {
-			return err
+			staged.Close()
+			os.Remove(staged.Name())
 		}
-		err = service.SaveFile(targetFile, documentContent)
+	}()
+
+	stream, err := scanner.Stream(h.c.Request().Context())
+	if err != nil {
+		return nil, h.scanFailed(err)
+	}
+	defer stream.Close()
+	if _, err := file.WriteTo(io.MultiWriter(staged, stream)); err != nil {
+		if stream.Err() != nil {
+			return nil, h.scanFailed(stream.Err())
+		}
+		return nil, err
+	}
+	result, err := stream.Result()
+	if err != nil {
+		return nil, h.scanFailed(err)
+	}
+	document.ScanResult = result.String()
+	if result.Infected {
+		log.Printf("Rejected upload of client %s infected with %s", h.client(), result.Signature)
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1190, "File is infected with "+result.Signature)
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return staged, nil
+}
+
+// scanFailed is the error of uploads clamd couldn't scan, such as those above its size limit.
+func (h *Handler) scanFailed(err error) *core.Error {
+	log.Printf("Can't scan upload of client %s: %s", h.client(), err)
+	if errors.Is(err, scan.ErrScan) {
+		return core.NewError(http.StatusUnprocessableEntity, 1200, "Can't scan file")
+	}
+	return core.NewError(http.StatusServiceUnavailable, 1200, "Can't scan file, try again later")
+}
 
+// sealMetadata records the SHA-256 of the content written for file, and the manifest of its archive,
+// sealed with the key of the content so that only the key reveals them. The SHA-256 of documents
+// encrypted to recipients is sealed to them, their files can't be listed without decrypting them.
+func sealMetadata(document *Document, file *service.File, sealer metadataSealer) error {
+	document.Archive = file.Archive
+	document.Files = len(file.Manifest)
+	if sealer == nil {
+		return nil
+	}
+	var err error
+	if document.Digest, err = sealer.Seal(file.SHA256); err != nil {
 		return err
+	}
+	if file.Archive == "" || document.Format == FormatAge {
+		return nil
+	}
+	manifest, err := json.Marshal(file.Manifest)
+	if err != nil {
+		return err
+	}
+	document.Manifest, err = sealer.Seal(manifest)
+	return err
+}
 
-	})
+// archive returns how multiple files are bundled: in the format of the optional "archive" form field,
+// the configured one otherwise.
+func (h *Handler) archive(form *multipart.Form) (service.Archive, *core.Error) {
+	archive := service.Archive{Format: service.ArchiveZip, Name: "archive", Level: service.DefaultCompression}
+	if cfg, ok := h.c.Get("config").(*config.Config); ok {
+		archive = service.Archive{Format: cfg.Archive.Format, Name: cfg.Archive.Name, Level: cfg.Archive.CompressionLevel}
+	}
+	if len(form.Value["archive"]) > 0 && form.Value["archive"][0] != "" {
+		archive.Format = form.Value["archive"][0]
+	}
+	if !service.ValidArchiveFormat(archive.Format) {
+		return archive, core.NewError(http.StatusBadRequest, 1180, "Archive format must be zip, tar, tar.gz or tar.zst")
+	}
+	return archive, nil
+}
+
+// validateText returns the secret text of the form, sent instead of files.
+func validateText(form *multipart.Form) (string, *core.Error) {
+	if len(form.Value["text"]) == 0 || strings.TrimSpace(form.Value["text"][0]) == "" {
+		return "", nil
+	}
+	if len(form.File["files"]) > 0 {
+		return "", core.NewError(http.StatusBadRequest, 1120, "Send either files or a secret text")
+	}
+	text := form.Value["text"][0]
+	if len(text) > maxTextSize {
+		return "", core.NewError(http.StatusBadRequest, 1130, "Secret text is too long")
+	}
+	return text, nil
+}
+
+func (h *Handler) Encrypt(form *multipart.Form) (*core.IDKey, error) {
+
+	text, e := validateText(form)
+	if e != nil {
+		return nil, e
+	}
+	var files []*multipart.FileHeader
+	size := len(text)
+	if text == "" {
+		files, e = h.validateFiles(form)
+		if e != nil {
+			return nil, e
+		}
+		size = getTotalFileSize(files)
+	}
+
+	o, e := parseOptions(form)
+	if e != nil {
+		return nil, e
+	}
+	archive, e := h.archive(form)
+	if e != nil {
+		return nil, e
+	}
+
+	owner, e := h.checkOwner(size)
+	if e != nil {
+		return nil, e
+	}
+
+	if e := h.checkUploadBytes(size); e != nil {
+		return nil, e
+	}
+
+	file := service.NewTextFile(text)
+	if text == "" {
+		file = service.GetFileFromFileHeader(files, form.Value["paths"], archive)
+		file.Check = h.contentCheck()
+	}
+
+	document := h.newDocument(file)
+	document.IsText = text != ""
+	if owner != nil {
+		document.OwnerID = &owner.ID
+	}
+	return h.encryptFile(&document, file, o, nil)
+}
+
+// options are how the sender wants a document to be encrypted.
+type options struct {
+	recipients []age.Recipient
+	shares     int
+	threshold  int
+	passphrase string
+	ageFormat  bool
+}
+
+// parseOptions returns the encryption options of the upload form.
+func parseOptions(form *multipart.Form) (*options, *core.Error) {
+	var o options
+	var e *core.Error
+	if o.recipients, e = parseRecipients(form); e != nil {
+		return nil, e
+	}
+	if o.shares, o.threshold, e = parseShares(form); e != nil {
+		return nil, e
+	}
+	if o.passphrase, e = parsePassphrase(form); e != nil {
+		return nil, e
+	}
+	o.ageFormat = len(form.Value["format"]) > 0 && form.Value["format"][0] == "age"
+	if o.shares > 0 && o.recipients != nil {
+		return nil, core.NewError(http.StatusBadRequest, 1100, "Documents encrypted to recipients have no key to split")
+	}
+	if o.passphrase != "" && (o.recipients != nil || o.ageFormat) {
+		return nil, core.NewError(http.StatusBadRequest, 1110, "A passphrase can't be added to age files")
+	}
+	return &o, nil
+}
+
+// encryptFile stores the content of file encrypted as chosen by o and returns the key of the new document.
+// before is passed to store.
+func (h *Handler) encryptFile(document *Document, file *service.File, o *options, before func(tx *gorm.DB) error) (*core.IDKey, error) {
+	manageToken, err := service.GenerateManageToken()
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	document.ManageTokenHash = h.e.HashString(manageToken)
+
+	key, keyFormat, err := h.newKey()
 	if err != nil {
 		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
 	}
+	document.KeyFormat = keyFormat
+	document.HasPassphrase = o.passphrase != ""
+	var encrypt encrypter
+	var sealer metadataSealer
+	if o.recipients != nil {
+		document.Format = FormatAge
+		encrypt = func(w io.Writer) (io.WriteCloser, error) {
+			return service.EncryptToRecipientsStream(o.recipients, w)
+		}
+		sealer = recipientsSealer(o.recipients)
+	} else {
+		// The key is derived once, to encrypt the content and seal its metadata.
+		streamKey, err := h.e.NewStreamKey(key, o.passphrase)
+		if err != nil {
+			return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+		}
+		sealer = streamKey
+		document.Format = FormatStream
+		encrypt = streamKey.Encrypt
+		if o.ageFormat {
+			document.Format = FormatAgePassphrase
+			encrypt = func(w io.Writer) (io.WriteCloser, error) {
+				return h.e.EncryptAgeStream(key, w)
+			}
+		}
+	}
+
+	idKey := &core.IDKey{
+		ID:          document.ID,
+		Key:         key,
+		ManageToken: manageToken,
+	}
+	if o.recipients != nil {
+		idKey.Key = ""
+	}
+	// A split key is only given as shares, so that no single person can decrypt the document.
+	if o.shares > 0 {
+		idKey.Shares, err = service.SplitKey(key, o.shares, o.threshold)
+		if err != nil {
+			return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+		}
+		idKey.Key = ""
+		document.Threshold = o.threshold
+	}
+
+	if err := h.store(document, file, encrypt, sealer, before); err != nil {
+		var coreErr *core.Error
+		if errors.As(err, &coreErr) {
+			return nil, coreErr
+		}
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	idKey.SHA256 = hex.EncodeToString(file.SHA256)
 
-	return &core.IDKey{
-		ID:  document.ID,
-		Key: passphrase,
-	}, nil
+	return idKey, nil
 }
 
-func (h *Handler) Check(ID string) error {
-	dr := NewRepositoryImp(h.DB)
-	d, err := dr.FindById(ID)
-	if err != nil {
-		return core.NewError(http.StatusUnprocessableEntity, 2000, "Can't find document")
+// privacy reports whether non-Ready documents must be indistinguishable from unknown ones.
+func (h *Handler) privacy() bool {
+	cfg, ok := h.c.Get("config").(*config.Config)
+	return ok && cfg.Privacy.UniformResponses
+}
+
+// resumeWindow is how long a download can be resumed after the key was first entered.
+func (h *Handler) resumeWindow() time.Duration {
+	if cfg, ok := h.c.Get("config").(*config.Config); ok && cfg.Downloads.ResumeWindow > 0 {
+		return cfg.Downloads.ResumeWindow
+	}
+	return defaultResumeWindow
+}
+
+// notFound is the error for unknown documents, and in privacy mode for every document that is not Ready.
+func notFound() *core.Error {
+	return core.NewError(http.StatusUnprocessableEntity, 2000, "Can't find document")
+}
+
+// checkStatus returns the error describing why d can't be downloaded, or nil if it is Ready or its
+// download can still be resumed.
+func (h *Handler) checkStatus(d *Document) *core.Error {
+	status := d.Status
+	// The cleanup job only marks the document Downloaded some time after its window closed.
+	if status == Downloading && (d.DownloadStartedAt == nil || time.Since(*d.DownloadStartedAt) > h.resumeWindow()) {
+		status = Downloaded
+	}
+	if status != Ready && status != Downloading && h.privacy() {
+		return notFound()
 	}
 
-	if d.Status == Downloaded {
+	if status == Downloaded {
 		return core.NewError(http.StatusUnprocessableEntity, 2010, "Document was already downloaded")
 	}
 
-	if d.Status == Expired {
+	if status == Expired {
 		return core.NewError(http.StatusUnprocessableEntity, 2020, "Document was expired")
 	}
 
-	if d.Status == MaxFailedAttempts {
+	if status == MaxFailedAttempts {
 		return core.NewError(http.StatusUnprocessableEntity, 2030, "Document reached max failed attempts")
 	}
 
 	return nil
 }
 
-func (h *Handler) Decrypt(ip *core.IDKey) ([]byte, *Document, error) {
+// Check returns the document if it can be downloaded.
+func (h *Handler) Check(ID string) (*Document, error) {
 	dr := NewRepositoryImp(h.DB)
-	d, err := dr.FindById(ip.ID)
+	d, err := dr.FindById(ID)
 	if err != nil {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2000, "Can't find document")
+		return nil, notFound()
 	}
 
-	if d.Status == Downloaded {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2010, "Document was already downloaded")
+	if e := h.checkStatus(d); e != nil {
+		return nil, e
 	}
+	return d, nil
+}
+
+// Export returns the stored age file of the document, to be decrypted offline. Like a download,
+// it can only be done once.
+func (h *Handler) Export(ID string) ([]byte, *Document, error) {
+	return h.retrieve(core.NewIDKey(ID, ""), true)
+}
 
-	if d.Status == Expired {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2020, "Document was expired")
+// retrieve returns the content of the document, decrypted with the key unless it is exported.
+func (h *Handler) retrieve(ip *core.IDKey, export bool) ([]byte, *Document, error) {
+	return h.unlock(ip, export, func(d *Document) ([]byte, error) {
+		return h.read(d, ip, export)
+	})
+}
+
+func (h *Handler) read(d *Document, ip *core.IDKey, export bool) ([]byte, error) {
+	src, err := os.Open(DataFolder + d.ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 2040, "Can't open file")
+	}
+	defer src.Close()
+	ciphertext, err := io.ReadAll(src)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 2050, "Can't read file")
+	}
+	// Documents encrypted to recipients are delivered as stored, only the recipients can decrypt them.
+	if export {
+		return ciphertext, nil
 	}
+	return h.decrypt(d, ip, ciphertext)
+}
+
+// Content is the decrypted content of a document, read from any offset. Documents in the stream format
+// are only decrypted from the chunk holding the offset, the others are decrypted whole when opened.
+type Content struct {
+	Size int64
+	// SHA256 is the hex SHA-256 of the content recorded at upload, "" if none was.
+	SHA256 string
+	// SealedSHA256 is the SHA-256 of the plaintext of documents encrypted to recipients, encrypted to them.
+	SealedSHA256 []byte
+
+	from   func(offset int64) (io.Reader, error)
+	closer io.Closer
+}
+
+// BytesContent returns the content held by b.
+func BytesContent(b []byte) *Content {
+	return &Content{Size: int64(len(b)), from: func(offset int64) (io.Reader, error) {
+		return bytes.NewReader(b[offset:]), nil
+	}}
+}
 
-	if d.Status == MaxFailedAttempts {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2030, "Document reached max failed attempts")
+// From returns a reader of the content from offset on.
+func (c *Content) From(offset int64) (io.Reader, error) {
+	return c.from(offset)
+}
+
+// Bytes reads the whole content.
+func (c *Content) Bytes() ([]byte, error) {
+	r, err := c.from(0)
+	if err != nil {
+		return nil, err
 	}
+	return io.ReadAll(r)
+}
 
-	targetPath := dataFolder + ip.ID
-	src, err := os.Open(targetPath)
+func (c *Content) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// contentReaderAt reads the content at any offset. Sequential reads go on with the same reader, so
+// that reading the content through it in order only decrypts it once.
+type contentReaderAt struct {
+	c   *Content
+	r   io.Reader
+	pos int64
+}
+
+func (ra *contentReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= ra.c.Size {
+		return 0, io.EOF
+	}
+	if ra.r == nil || off != ra.pos {
+		r, err := ra.c.From(off)
+		if err != nil {
+			return 0, err
+		}
+		ra.r, ra.pos = r, off
+	}
+	n, err := io.ReadFull(ra.r, p)
+	ra.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// archiveFile is a file of the archive of a document. It is extracted again for each offset it is
+// read from, only the file being read is kept open.
+type archiveFile struct {
+	archive *Content
+	format  string
+	index   int
+	// r is the file opened last, unread when fresh is set.
+	r     io.ReadCloser
+	fresh bool
+}
+
+func (f *archiveFile) from(offset int64) (io.Reader, error) {
+	if !f.fresh {
+		f.r.Close()
+		_, _, r, err := service.OpenFile(f.format, &contentReaderAt{c: f.archive}, f.archive.Size, f.index)
+		if err != nil {
+			f.r = io.NopCloser(nil)
+			return nil, err
+		}
+		f.r = r
+	}
+	f.fresh = false
+	if _, err := io.CopyN(io.Discard, f.r, offset); err != nil {
+		return nil, err
+	}
+	return f.r, nil
+}
+
+func (f *archiveFile) Close() error {
+	f.r.Close()
+	return f.archive.Close()
+}
+
+// Open checks the key and returns the content of the document, to be read from the offset
+// of a byte range. The content must be closed.
+func (h *Handler) Open(ip *core.IDKey) (*Content, *Document, error) {
+	content, listing, d, err := h.open(ip)
 	if err != nil {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2040, "Can't open file")
+		return nil, nil, err
 	}
-	ciphertext, err := io.ReadAll(src)
+	content.SHA256 = listing.SHA256
+	content.SealedSHA256 = listing.SealedSHA256
+	return content, d, nil
+}
+
+// open returns the content of the document with its metadata. The key of documents in the stream
+// format is derived once for both.
+func (h *Handler) open(ip *core.IDKey) (*Content, *Listing, *Document, error) {
+	var content *Content
+	var key *service.StreamKey
+	_, d, err := h.unlock(ip, false, func(d *Document) ([]byte, error) {
+		if d.Format != FormatStream {
+			plaintext, err := h.read(d, ip, false)
+			if err != nil {
+				return nil, err
+			}
+			content = BytesContent(plaintext)
+			return nil, nil
+		}
+		src, err := os.Open(DataFolder + d.ID)
+		if err != nil {
+			return nil, core.NewError(http.StatusUnprocessableEntity, 2040, "Can't open file")
+		}
+		info, err := src.Stat()
+		if err != nil {
+			src.Close()
+			return nil, core.NewError(http.StatusUnprocessableEntity, 2050, "Can't read file")
+		}
+		key, err = h.e.ReadStreamKey(ip.Key, ip.Passphrase, src)
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		stream, err := key.OpenStream(src, info.Size())
+		if err != nil {
+			src.Close()
+			return nil, err
+		}
+		content = &Content{Size: stream.Size(), from: stream.From, closer: src}
+		return nil, nil
+	})
+	if err != nil {
+		if content != nil {
+			content.Close()
+		}
+		return nil, nil, nil, err
+	}
+	listing, err := h.unsealMetadata(d, ip, key)
+	if err != nil {
+		content.Close()
+		return nil, nil, nil, core.NewError(http.StatusUnprocessableEntity, 2180, "Can't read the SHA-256 of the document")
+	}
+	return content, listing, d, nil
+}
+
+// normalizeKey sets the key of ip from its key shares, or in the normalized form of word keys.
+func normalizeKey(d *Document, ip *core.IDKey) *core.Error {
+	if d.Threshold > 0 {
+		if len(ip.Shares) < d.Threshold {
+			return core.NewError(http.StatusBadRequest, 2130, fmt.Sprintf("Enter at least %d key shares", d.Threshold))
+		}
+		key, err := service.CombineKey(ip.Shares)
+		if err != nil {
+			return core.NewError(http.StatusBadRequest, 2140, "Invalid key share")
+		}
+		ip.Key = key
+	}
+	if d.KeyFormat == KeyWords {
+		ip.Key = service.NormalizeWordKey(ip.Key)
+	}
+	// A missing key is not a wrong one, it doesn't count as a failed attempt.
+	if ip.Key == "" && d.Format != FormatAge {
+		return core.NewError(http.StatusBadRequest, 2190, "Enter the key")
+	}
+	return nil
+}
+
+// resumeKeyHash hashes the normalized key and passphrase of ip for the document.
+func (h *Handler) resumeKeyHash(d *Document, ip *core.IDKey) string {
+	return h.e.HashString(d.ID + "\x00" + ip.Key + "\x00" + ip.Passphrase)
+}
+
+// Resuming reports whether ip resumes the download of a document in its resume window, with the key
+// that opened the window. It doesn't decrypt the document, so it is cheap enough to run before the
+// rate limits, which resumed downloads don't spend again.
+func (h *Handler) Resuming(ip *core.IDKey) bool {
+	d, err := NewRepositoryImp(h.DB).FindById(ip.ID)
+	if err != nil || d.Status != Downloading || d.ResumeKeyHash == "" ||
+		d.DownloadStartedAt == nil || time.Since(*d.DownloadStartedAt) > h.resumeWindow() {
+		return false
+	}
+	key := *ip
+	if normalizeKey(d, &key) != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(d.ResumeKeyHash), []byte(h.resumeKeyHash(d, &key))) == 1
+}
+
+// unlock checks the key against the document and returns what decrypt reads from it. A decrypt error
+// other than a *core.Error counts as a wrong key. The first time it opens the resume window of the
+// document, which is Downloaded once Delivered reports all its content.
+func (h *Handler) unlock(ip *core.IDKey, export bool, decrypt func(d *Document) ([]byte, error)) ([]byte, *Document, error) {
+	if e := h.checkBan(); e != nil {
+		return nil, nil, e
+	}
+
+	dr := NewRepositoryImp(h.DB)
+	d, err := dr.FindById(ip.ID)
 	if err != nil {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2050, "Can't read file")
+		h.recordFailure()
+		return nil, nil, notFound()
+	}
+
+	if e := h.checkStatus(d); e != nil {
+		return nil, nil, e
+	}
+	if export && !d.Exportable() {
+		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2120, "Document is not stored in the age format")
+	}
+	if !export {
+		if e := normalizeKey(d, ip); e != nil {
+			return nil, nil, e
+		}
+	}
+	if d.HasPassphrase && ip.Passphrase == "" && !export {
+		return nil, nil, core.NewError(http.StatusBadRequest, 2150, "Enter the passphrase of the sender")
 	}
+
 	now := time.Now()
-	documentContent, err := h.e.Decrypt(ip.Key, ciphertext)
+	documentContent, err := decrypt(d)
+	var coreErr *core.Error
+	if errors.As(err, &coreErr) {
+		return nil, nil, coreErr
+	}
 	if err != nil {
 
 		d.FailedAttempts++
 		d.UpdatedAt = &now
 		if d.FailedAttempts >= 3 {
 			d.Status = MaxFailedAttempts
-			err = os.Remove(targetPath)
+			err = os.Remove(DataFolder + d.ID)
 			if err != nil {
 				return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2060, "Can't remove file")
 			}
@@ -191,34 +941,210 @@ func (h *Handler) Decrypt(ip *core.IDKey) ([]byte, *Document, error) {
 			return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2070, "Can't update file")
 		}
 
+		h.recordFailure()
+		if d.HasPassphrase {
+			return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2080, "Wrong key or passphrase, try again")
+		}
 		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2080, "Wrong key, try again")
 	}
 
+	if d.Status == Ready {
+		d.Status = Downloading
+		d.DownloadStartedAt = &now
+		d.UpdatedAt = &now
+		d.ResumeKeyHash = h.resumeKeyHash(d, ip)
+		if err := dr.Update(d); err != nil {
+			return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2070, "Can't update file")
+		}
+	}
+
+	return documentContent, d, nil
+
+}
+
+// decrypt returns the plaintext of ciphertext, encrypted in the format of the document.
+func (h *Handler) decrypt(d *Document, ip *core.IDKey, ciphertext []byte) ([]byte, error) {
+	switch d.Format {
+	case FormatPassphrase:
+		return h.e.DecryptWithPassphrase(ip.Key, ip.Passphrase, ciphertext)
+	case FormatAgePassphrase:
+		return h.e.DecryptAge(ip.Key, ciphertext)
+	case FormatStream:
+		return h.decryptStream(ip, ciphertext)
+	}
+	return ciphertext, nil
+}
+
+// Listing describes the content of a document, shown once the key is entered.
+type Listing struct {
+	// SHA256 is the hex SHA-256 of the whole content, recorded at upload.
+	SHA256 string `json:"sha256"`
+	// Files are the files of the archive of the document, if any.
+	Files []service.ManifestEntry `json:"files"`
+	// SealedSHA256 is the SHA-256 of documents encrypted to recipients, only they can decrypt it.
+	SealedSHA256 []byte `json:"-"`
+}
+
+// List returns the SHA-256 and the files of the document, decrypted with the key. Secret texts and
+// documents without them return none, the key is then only checked when they are decrypted.
+func (h *Handler) List(ip *core.IDKey) (*Listing, *Document, error) {
+	d, err := NewRepositoryImp(h.DB).FindById(ip.ID)
+	if err != nil || d.IsText || d.Format == FormatAge || (d.Digest == nil && !d.HasManifest()) {
+		return nil, d, nil
+	}
+	var listing *Listing
+	_, d, err = h.unlock(ip, false, func(d *Document) ([]byte, error) {
+		var err error
+		listing, err = h.unsealMetadata(d, ip, nil)
+		return nil, err
+	})
 	if err != nil {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2070, "Can't update file")
+		return nil, nil, err
 	}
+	return listing, d, nil
+}
 
-	err = h.DB.Transaction(func(tx *gorm.DB) error {
-		now := time.Now()
+// unsealMetadata decrypts the SHA-256 and the manifest of the document with key, the key of its content,
+// or when they were sealed with another salt with a key derived once for both.
+func (h *Handler) unsealMetadata(d *Document, ip *core.IDKey, key *service.StreamKey) (*Listing, error) {
+	listing := &Listing{}
+	if d.Format == FormatAge {
+		listing.SealedSHA256 = d.Digest
+		return listing, nil
+	}
+	sealed := d.Digest
+	if sealed == nil {
+		sealed = d.Manifest
+	}
+	if sealed == nil {
+		return listing, nil
+	}
+	if key == nil || !key.Seals(sealed) {
+		var err error
+		if key, err = h.e.SealedKey(ip.Key, ip.Passphrase, sealed); err != nil {
+			return nil, err
+		}
+	}
+	if d.Digest != nil {
+		digest, err := key.Open(d.Digest)
+		if err != nil {
+			return nil, err
+		}
+		listing.SHA256 = hex.EncodeToString(digest)
+	}
+	if d.HasManifest() {
+		content, err := key.Open(d.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &listing.Files); err != nil {
+			return nil, core.NewError(http.StatusUnprocessableEntity, 2160, "Can't read the list of files")
+		}
+	}
+	return listing, nil
+}
+
+// DecryptFile returns the name and the content of the index-th file of the archive of the document,
+// with the SHA-256 of the file. The file is decrypted and extracted as it is read, the content must
+// be closed.
+func (h *Handler) DecryptFile(ip *core.IDKey, index int) (string, *Content, *Document, error) {
+	archive, listing, d, err := h.open(ip)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if !d.HasManifest() || index < 0 || index >= d.Files || index >= len(listing.Files) {
+		archive.Close()
+		return "", nil, nil, core.NewError(http.StatusBadRequest, 2170, "Can't find file in the document")
+	}
+	name, size, r, err := service.OpenFile(d.Archive, &contentReaderAt{c: archive}, archive.Size, index)
+	if err != nil {
+		archive.Close()
+		return "", nil, nil, core.NewError(http.StatusBadRequest, 2170, "Can't find file in the document")
+	}
+	file := &archiveFile{archive: archive, format: d.Archive, index: index, r: r, fresh: true}
+	content := &Content{Size: size, SHA256: listing.Files[index].SHA256, from: file.from, closer: file}
+	return path.Base(name), content, d, nil
+}
+
+func (h *Handler) decryptStream(ip *core.IDKey, ciphertext []byte) ([]byte, error) {
+	r, err := h.e.DecryptStream(ip.Key, ip.Passphrase, bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// Delivered records that the bytes [start, end) of the size bytes of content of the document were sent,
+// for the file of its archive at index file or for AllFiles. Once every byte of the content, or every
+// file, was, the document is Downloaded and its file removed.
+func (h *Handler) Delivered(ID string, file int, start, end, size int64) error {
+	return h.DB.Transaction(func(tx *gorm.DB) error {
 		dr := NewRepositoryImp(tx)
-		d.Status = Downloaded
-		d.DownloadedAt = &now
-		d.UpdatedAt = &now
-		err := dr.Update(d)
+		d, err := dr.FindByIdForUpdate(ID)
 		if err != nil {
 			return err
 		}
-		err = os.Remove(targetPath)
-		if err != nil {
+		if d.Status != Downloading {
+			return nil
+		}
+		now := time.Now()
+		d.UpdatedAt = &now
+		var complete bool
+		if file == AllFiles {
+			ranges := parseByteRanges(d.DeliveredRanges).add(start, end)
+			d.DeliveredRanges = ranges.String()
+			if complete = ranges.covers(size); complete {
+				d.FilesDownloaded = d.Files
+			}
+		} else {
+			d.deliverFile(file, start, end, size)
+			complete = d.FilesDownloaded >= d.Files
+		}
+		if !complete {
+			return dr.Update(d)
+		}
+		d.Status = Downloaded
+		d.DownloadedAt = &now
+		d.ResumeKeyHash = ""
+		if err := dr.Update(d); err != nil {
 			return err
 		}
-
-		return nil
+		return os.Remove(DataFolder + d.ID)
 	})
+}
+
+// Manage returns the document for its sender's management view, whatever its status.
+// The sender is identified by the management token or by being the signed-in owner.
+func (h *Handler) Manage(ID, token string) (*Document, error) {
+	dr := NewRepositoryImp(h.DB)
+	d, err := dr.FindById(ID)
 	if err != nil {
-		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 2080, "Can't remove file")
+		return nil, core.NewError(http.StatusNotFound, 2200, "Can't find document")
 	}
+	if u := account.CurrentUser(h.c); u != nil && d.OwnerID != nil && *d.OwnerID == u.ID {
+		return d, nil
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(d.ManageTokenHash), []byte(h.e.HashString(token))) != 1 {
+		return nil, core.NewError(http.StatusNotFound, 2200, "Can't find document")
+	}
+	return d, nil
+}
 
-	return documentContent, d, nil
+// Shares lists the documents uploaded by u, the latest first.
+func (h *Handler) Shares(u *account.User) ([]Document, error) {
+	documents, err := NewRepositoryImp(h.DB).FindByOwner(u.ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 2210, "Can't get documents")
+	}
+	return documents, nil
+}
 
+// Find returns the document whatever its status, for admins.
+func (h *Handler) Find(ID string) (*Document, error) {
+	dr := NewRepositoryImp(h.DB)
+	d, err := dr.FindById(ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusNotFound, 2200, "Can't find document")
+	}
+	return d, nil
 }
@@ -0,0 +1,384 @@
+package document
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"dataShare/core"
+	"dataShare/scan"
+	"dataShare/scan/scantest"
+	"dataShare/service"
+	"encoding/hex"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateText(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     multipart.Form
+		expected string
+		code     int
+	}{
+		{"NoText", multipart.Form{}, "", 0},
+		{"Blank", multipart.Form{Value: map[string][]string{"text": {" \n"}}}, "", 0},
+		{"Text", multipart.Form{Value: map[string][]string{"text": {"secret"}}}, "secret", 0},
+		{"TooLong", multipart.Form{Value: map[string][]string{"text": {strings.Repeat("a", maxTextSize+1)}}}, "", 1130},
+		{"WithFiles", multipart.Form{
+			Value: map[string][]string{"text": {"secret"}},
+			File:  map[string][]*multipart.FileHeader{"files": {{Filename: "a.txt"}}},
+		}, "", 1120},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, err := validateText(&tt.form)
+			if tt.code != 0 {
+				if err == nil || err.Code != tt.code {
+					t.Fatalf("Expected error code %d, but got %v", tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if text != tt.expected {
+				t.Fatalf("Expected text %q, but got %q", tt.expected, text)
+			}
+		})
+	}
+}
+
+func TestSealMetadata(t *testing.T) {
+	h := &Handler{e: service.NewEncryption(1000, 32, 16, "salt")}
+	sealer, err := h.e.NewStreamKey("key", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := service.NewTextFile("secret")
+	if _, err := file.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		sealer   *service.StreamKey
+		expected string
+	}{
+		{"Stream", sealer, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{"NoKey", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Document{}
+			var sealer metadataSealer
+			if tt.sealer != nil {
+				sealer = tt.sealer
+			}
+			if err := sealMetadata(d, file, sealer); err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range []*service.StreamKey{nil, tt.sealer} {
+				listing, err := h.unsealMetadata(d, core.NewIDKey("", "key"), key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if listing.SHA256 != tt.expected {
+					t.Fatalf("Expected SHA-256 %q, but got %q", tt.expected, listing.SHA256)
+				}
+			}
+			if _, err := h.unsealMetadata(d, core.NewIDKey("", "wrong"), nil); tt.expected != "" && err == nil {
+				t.Fatalf("Expected an error with the wrong key, but got none")
+			}
+		})
+	}
+}
+
+func TestSealMetadata_Recipients(t *testing.T) {
+	h := &Handler{e: service.NewEncryption(1000, 32, 16, "salt")}
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := service.NewTextFile("secret")
+	if _, err := file.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	d := &Document{Format: FormatAge}
+	if err := sealMetadata(d, file, recipientsSealer{identity.Recipient()}); err != nil {
+		t.Fatal(err)
+	}
+	// The server has no key to check, the SHA-256 is given sealed to the recipients.
+	listing, err := h.unsealMetadata(d, core.NewIDKey("", ""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listing.SHA256 != "" {
+		t.Fatalf("Expected no SHA-256 readable by the server, but got %q", listing.SHA256)
+	}
+	r, err := age.Decrypt(bytes.NewReader(listing.SealedSHA256), identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"; hex.EncodeToString(digest) != expected {
+		t.Fatalf("Expected SHA-256 %q, but got %x", expected, digest)
+	}
+}
+
+func TestWriteEncrypted_Scan(t *testing.T) {
+	server, err := scantest.NewServer("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.MaxStreamSize = 1 << 10
+	stopped, err := scantest.NewServer("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped.Close()
+
+	tests := []struct {
+		name    string
+		scanner *scan.Client
+		content string
+		result  string
+		code    int
+	}{
+		{"NotScanned", nil, "hello", "", 0},
+		{"Clean", server.Client(), "hello", "OK", 0},
+		{"Infected", server.Client(), scantest.EICAR, scantest.Signature, 1190},
+		{"TooLarge", server.Client(), strings.Repeat("a", 2<<10), "", 1200},
+		{"Unreachable", stopped.Client(), "hello", "", 1200},
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/upload", nil), httptest.NewRecorder())
+			c.Set("client", "client")
+			if tt.scanner != nil {
+				c.Set("scanner", tt.scanner)
+			}
+			h := &Handler{c: c}
+			d := &Document{}
+			target := filepath.Join(t.TempDir(), "document")
+			encrypt := func(w io.Writer) (io.WriteCloser, error) { return nopCloser{w}, nil }
+			err := h.writeEncrypted(target, d, service.NewTextFile(tt.content), encrypt)
+			var coreErr *core.Error
+			if errors.As(err, &coreErr) && coreErr.Code != tt.code || err != nil && coreErr == nil || err == nil && tt.code != 0 {
+				t.Fatalf("Expected error code %d, but got %v", tt.code, err)
+			}
+			if d.ScanResult != tt.result {
+				t.Fatalf("Expected scan result %q, but got %q", tt.result, d.ScanResult)
+			}
+			// Rejected content is never written to the target, and no staged plaintext is left behind.
+			if _, err := os.Stat(target); tt.code != 0 && !os.IsNotExist(err) {
+				t.Fatalf("Expected no file for rejected content, but got %v", err)
+			}
+			if content, err := os.ReadFile(target); tt.code == 0 && string(content) != tt.content {
+				t.Fatalf("Expected content %q, but got %q (%v)", tt.content, content, err)
+			}
+			if staged, _ := os.ReadDir(StagingFolder); len(staged) != 0 {
+				t.Fatalf("Expected no staged file, but got %d", len(staged))
+			}
+		})
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestResuming(t *testing.T) {
+	e := service.NewEncryption(1000, 32, 16, "salt")
+	started := time.Now().Add(-time.Minute)
+	closed := time.Now().Add(-time.Hour)
+	hash := e.HashString("doc_1\x00key\x00")
+	tests := []struct {
+		name      string
+		status    int
+		startedAt *time.Time
+		key       string
+		expected  bool
+	}{
+		{"Resumed", Downloading, &started, "key", true},
+		{"WrongKey", Downloading, &started, "other", false},
+		{"WindowClosed", Downloading, &closed, "key", false},
+		{"NotStarted", Ready, nil, "key", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockDb.Close()
+			mock.ExpectQuery("SELECT (.+) FROM \"documents\" WHERE id = (.+)").
+				WithArgs("doc_1").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "status", "download_started_at", "resume_key_hash"}).
+					AddRow("doc_1", tt.status, tt.startedAt, hash))
+			db, _ := gorm.Open(postgres.New(postgres.Config{Conn: mockDb, DriverName: "postgres"}), &gorm.Config{})
+
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+			h := NewHandler(c, db, e)
+			if resuming := h.Resuming(core.NewIDKey("doc_1", tt.key)); resuming != tt.expected {
+				t.Fatalf("Expected resuming %v, but got %v", tt.expected, resuming)
+			}
+		})
+	}
+}
+
+func TestUnlock_MissingKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		format int
+		export bool
+		code   int
+	}{
+		{"Key", FormatAgePassphrase, false, 2190},
+		{"Export", FormatAgePassphrase, true, 0},
+		{"Recipients", FormatAge, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockDb.Close()
+			mock.ExpectQuery("SELECT (.+) FROM \"documents\" WHERE id = (.+)").
+				WithArgs("doc_1").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "status", "download_started_at", "format"}).
+					AddRow("doc_1", Downloading, time.Now(), tt.format))
+			db, _ := gorm.Open(postgres.New(postgres.Config{Conn: mockDb, DriverName: "postgres"}), &gorm.Config{})
+
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+			h := NewHandler(c, db, service.NewEncryption(1000, 32, 16, "salt"))
+			decrypted := false
+			_, _, err = h.unlock(core.NewIDKey("doc_1", ""), tt.export, func(d *Document) ([]byte, error) {
+				decrypted = true
+				return nil, nil
+			})
+			var coreErr *core.Error
+			if errors.As(err, &coreErr) && coreErr.Code != tt.code || err != nil && coreErr == nil || err == nil && tt.code != 0 {
+				t.Fatalf("Expected error code %d, but got %v", tt.code, err)
+			}
+			// A missing key is rejected before decrypting, it is not counted as a failed attempt.
+			if decrypted != (tt.code == 0) {
+				t.Fatalf("Expected decrypted %v, but got %v", tt.code == 0, decrypted)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDecryptFile_Stream(t *testing.T) {
+	e := service.NewEncryption(1000, 32, 16, "salt")
+	files := []string{"first", strings.Repeat("0123456789abcdef", 10<<10)}
+	archives := map[string]func(w io.Writer) error{
+		service.ArchiveZip: func(w io.Writer) error {
+			zw := zip.NewWriter(w)
+			for i, content := range files {
+				fw, err := zw.Create(fmt.Sprintf("file%d", i))
+				if err != nil {
+					return err
+				}
+				if _, err := io.WriteString(fw, content); err != nil {
+					return err
+				}
+			}
+			return zw.Close()
+		},
+		service.ArchiveTar: func(w io.Writer) error {
+			tw := tar.NewWriter(w)
+			for i, content := range files {
+				if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: fmt.Sprintf("file%d", i), Size: int64(len(content)), Mode: 0644}); err != nil {
+					return err
+				}
+				if _, err := io.WriteString(tw, content); err != nil {
+					return err
+				}
+			}
+			return tw.Close()
+		},
+	}
+	for format, write := range archives {
+		t.Run(format, func(t *testing.T) {
+			key, err := e.NewStreamKey("key", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ciphertext bytes.Buffer
+			w, err := key.Encrypt(&ciphertext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := write(w); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			stream, err := key.OpenStream(bytes.NewReader(ciphertext.Bytes()), int64(ciphertext.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			archive := &Content{Size: stream.Size(), from: stream.From}
+
+			name, size, r, err := service.OpenFile(format, &contentReaderAt{c: archive}, archive.Size, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != "file1" || size != int64(len(files[1])) {
+				t.Fatalf("Expected file1 of %d bytes, but got %s of %d", len(files[1]), name, size)
+			}
+			file := &archiveFile{archive: archive, format: format, index: 1, r: r, fresh: true}
+			content := &Content{Size: size, from: file.from, closer: file}
+			defer content.Close()
+			// The second offset is in another chunk of the stream, the file is extracted again to read from it.
+			for _, offset := range []int64{0, 100000} {
+				r, err := content.From(offset)
+				if err != nil {
+					t.Fatal(err)
+				}
+				plaintext, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(plaintext) != files[1][offset:] {
+					t.Fatalf("Expected %d bytes from offset %d, but got %d", len(files[1])-int(offset), offset, len(plaintext))
+				}
+			}
+		})
+	}
+}
@@ -1,14 +1,43 @@
 package document
 
 import (
+	"fmt"
 	"time"
 )
 
+// ExpiresAfter is how long a Ready document is kept before the cleanup job expires it.
+const ExpiresAfter = 24 * time.Hour
+
 const (
 	Ready = iota
 	Downloaded
 	Expired
 	MaxFailedAttempts
+	// Downloading documents were decrypted once and can be downloaded again, in byte ranges, until
+	// every byte was delivered or their resume window closes.
+	Downloading
+)
+
+// Formats of the stored content.
+const (
+	// FormatPassphrase is AES-GCM with a key derived from the passphrase given to the sender. Documents
+	// uploaded since FormatStream exists use it instead.
+	FormatPassphrase = iota
+	// FormatAge is the age format encrypted to the public keys of the recipients, the server can't decrypt it.
+	FormatAge
+	// FormatAgePassphrase is the age format encrypted with the passphrase given to the sender (scrypt), it can
+	// be decrypted by the server or exported and decrypted offline with "age -d".
+	FormatAgePassphrase
+	// FormatStream is AES-GCM in chunks, see service.EncryptStream, with the same key as FormatPassphrase.
+	// Unlike FormatPassphrase it is written while the upload is read.
+	FormatStream
+)
+
+// Formats of the generated keys.
+const (
+	KeyCharacters = iota
+	// KeyWords keys are compared whatever their case and the separators between the words.
+	KeyWords
 )
 
 type Document struct {
@@ -22,4 +51,142 @@ type Document struct {
 	DownloadedAt    *time.Time `gorm:"nullable;columName:downloaded_at"`
 	UpdatedAt       *time.Time `gorm:"nullable;columName:updated_at"`
 	Client          string     `gorm:"not null;size:65"`
+	ManageTokenHash string     `gorm:"not null;default:'';size:65"`
+	OwnerID         *string    `gorm:"nullable;index;size:36"`
+	UploadRequestID *string    `gorm:"nullable;index;size:36"`
+	SealedKey       string     `gorm:"not null;default:'';type:text"`
+	Format          int        `gorm:"not null;default:0"`
+	Threshold       int        `gorm:"not null;default:0"`
+	HasPassphrase   bool       `gorm:"not null;default:false"`
+	KeyFormat       int        `gorm:"not null;default:0"`
+	IsText          bool       `gorm:"not null;default:false"`
+	// DownloadStartedAt is when the key was first entered, it opens the resume window.
+	DownloadStartedAt *time.Time `gorm:"nullable"`
+	// ResumeKeyHash is the hash of the key that opened the resume window, the resumed downloads of the
+	// window are recognized by it. It is cleared once the document is downloaded.
+	ResumeKeyHash string `gorm:"not null;default:'';size:65"`
+	// DeliveredRanges are the byte ranges of the content delivered so far, see byteRanges.
+	DeliveredRanges string `gorm:"not null;default:''"`
+	// Digest is the SHA-256 of the content, encrypted like it. Documents encrypted to recipients, and
+	// those uploaded before it was recorded, have none.
+	Digest []byte `gorm:"nullable"`
+	// ScanResult is the verdict of the malware scan of the content, "OK", or "" when it wasn't scanned.
+	ScanResult string `gorm:"not null;default:'';size:255"`
+	// Archive is the format of the archive bundling the files of the document, "" for a single file.
+	Archive string `gorm:"not null;default:'';size:16"`
+	// Files is the number of files of the archive.
+	Files int `gorm:"not null;default:0"`
+	// Manifest lists the files of the archive as JSON, encrypted like the content. Documents encrypted to
+	// recipients have none, the server couldn't read it.
+	Manifest []byte `gorm:"nullable"`
+	// DeliveredFiles are the byte ranges delivered so far of the files downloaded on their own, see Delivered.
+	DeliveredFiles string `gorm:"not null;default:''"`
+	// FilesDownloaded is how many files of the archive were completely downloaded.
+	FilesDownloaded int `gorm:"not null;default:0"`
+}
+
+// AllFiles selects the whole content of a document rather than a single file of its archive.
+const AllFiles = -1
+
+// Exportable reports whether the stored content is a standard age file.
+func (d *Document) Exportable() bool {
+	return d.Format == FormatAge || d.Format == FormatAgePassphrase
+}
+
+// Attachment returns the file name and content type the document is downloaded with. Exported
+// documents, and those encrypted to recipients, are served encrypted as .age files.
+func (d *Document) Attachment(exported bool) (string, string) {
+	if exported || d.Format == FormatAge {
+		return d.Filename + ".age", "application/octet-stream"
+	}
+	return d.Filename, d.FileContentType
+}
+
+// ShowsText reports whether the downloaded content is a secret text, shown in the page instead of
+// downloaded as a file. Secret texts stay files when they are served encrypted.
+func (d *Document) ShowsText(exported bool) bool {
+	return d.IsText && !exported && d.Format != FormatAge
+}
+
+// HasManifest reports whether the files of the document can be listed and downloaded one by one.
+func (d *Document) HasManifest() bool {
+	return d.Manifest != nil && d.Files > 0
+}
+
+// UploadRequest lets anyone with its link upload documents for its owner. The key of each document
+// is sealed to PublicKey; the matching private key is only given to the owner when the request is created.
+type UploadRequest struct {
+	ID        string     `gorm:"primaryKey;size:36"`
+	OwnerID   string     `gorm:"not null;index;size:36"`
+	Title     string     `gorm:"not null;size:255"`
+	PublicKey string     `gorm:"not null;size:64"`
+	MaxSize   int64      `gorm:"not null"`
+	OneTime   bool       `gorm:"not null;default:false"`
+	Uploads   int        `gorm:"not null;default:0"`
+	CreatedAt time.Time  `gorm:"not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	ClosedAt  *time.Time `gorm:"nullable"`
+}
+
+// Open reports whether the request still accepts uploads.
+func (r *UploadRequest) Open() bool {
+	return r.ClosedAt == nil && time.Now().Before(r.ExpiresAt) && (!r.OneTime || r.Uploads == 0)
+}
+
+// Upload is a resumable upload whose content is written to the staging folder in chunks. It becomes
+// a document once its Length bytes are received.
+type Upload struct {
+	ID          string    `gorm:"primaryKey;size:36"`
+	OwnerID     *string   `gorm:"nullable;size:36"`
+	Client      string    `gorm:"not null;size:65"`
+	Filename    string    `gorm:"not null;size:255"`
+	ContentType string    `gorm:"not null;size:255"`
+	Length      int64     `gorm:"not null"`
+	Offset      int64     `gorm:"not null;default:0"`
+	CreatedAt   time.Time `gorm:"not null"`
+	UpdatedAt   time.Time `gorm:"not null;index"`
+}
+
+// Complete reports whether every byte of the upload was received.
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// RequestStatusName returns the human readable status of an upload request.
+func RequestStatusName(r *UploadRequest) string {
+	switch {
+	case r.ClosedAt != nil:
+		return "Closed"
+	case !time.Now().Before(r.ExpiresAt):
+		return "Expired"
+	case r.OneTime && r.Uploads > 0:
+		return "Used"
+	}
+	return "Open"
+}
+
+// ShareStatus returns the human readable status of the document, with how many files of its archive
+// were downloaded.
+func ShareStatus(d *Document) string {
+	if d.Files == 0 {
+		return StatusName(d.Status)
+	}
+	return fmt.Sprintf("%s, %d of %d files downloaded", StatusName(d.Status), d.FilesDownloaded, d.Files)
+}
+
+// StatusName returns the human readable name of a document status.
+func StatusName(status int) string {
+	switch status {
+	case Ready:
+		return "Ready"
+	case Downloaded:
+		return "Downloaded"
+	case Expired:
+		return "Expired"
+	case MaxFailedAttempts:
+		return "Locked after too many failed attempts"
+	case Downloading:
+		return "Downloading"
+	}
+	return "Unknown"
 }
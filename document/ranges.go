@@ -0,0 +1,117 @@
+package document
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// byteRanges are sorted, disjoint and non-adjacent [start, end) ranges of bytes, stored as "start-end,start-end".
+type byteRanges [][2]int64
+
+func parseByteRanges(s string) byteRanges {
+	var ranges byteRanges
+	for _, part := range strings.Split(s, ",") {
+		var start, end int64
+		if _, err := fmt.Sscanf(part, "%d-%d", &start, &end); err == nil {
+			ranges = ranges.add(start, end)
+		}
+	}
+	return ranges
+}
+
+func (r byteRanges) String() string {
+	parts := make([]string, len(r))
+	for i, rg := range r {
+		parts[i] = fmt.Sprintf("%d-%d", rg[0], rg[1])
+	}
+	return strings.Join(parts, ",")
+}
+
+// add returns the ranges with [start, end) merged in.
+func (r byteRanges) add(start, end int64) byteRanges {
+	if start >= end {
+		return r
+	}
+	merged := byteRanges{}
+	for _, rg := range r {
+		if rg[1] < start || rg[0] > end {
+			merged = append(merged, rg)
+			continue
+		}
+		start, end = min(start, rg[0]), max(end, rg[1])
+	}
+	merged = append(merged, [2]int64{start, end})
+	sort.Slice(merged, func(i, j int) bool { return merged[i][0] < merged[j][0] })
+	return merged
+}
+
+// covers reports whether every byte of content of size bytes is in the ranges.
+func (r byteRanges) covers(size int64) bool {
+	return size <= 0 || (len(r) > 0 && r[0][0] <= 0 && r[0][1] >= size)
+}
+
+// fileDelivered marks the files of DeliveredFiles that were completely delivered.
+const fileDelivered = "done"
+
+// deliverFile records that the bytes [start, end) of the file of size bytes at index file were delivered,
+// and counts the file as downloaded once all were. DeliveredFiles maps the index of each file to its
+// delivered byteRanges, as JSON.
+func (d *Document) deliverFile(file int, start, end, size int64) {
+	files := map[int]string{}
+	if d.DeliveredFiles != "" {
+		json.Unmarshal([]byte(d.DeliveredFiles), &files)
+	}
+	if files[file] == fileDelivered {
+		return
+	}
+	ranges := parseByteRanges(files[file]).add(start, end)
+	files[file] = ranges.String()
+	if ranges.covers(size) {
+		files[file] = fileDelivered
+		d.FilesDownloaded++
+	}
+	delivered, _ := json.Marshal(files)
+	d.DeliveredFiles = string(delivered)
+}
+
+// ParseRange returns the [start, end) bytes of content of size bytes asked for by a Range header with
+// a single range. Other headers ask for the whole content. ok is false when the range is outside of the content.
+func ParseRange(header string, size int64) (start, end int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size, true
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, size, true
+	}
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, size, true
+		}
+		if suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		return max(size-suffix, 0), size, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, true
+	}
+	end = size
+	if last != "" {
+		lastByte, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || lastByte < start {
+			return 0, size, true
+		}
+		end = min(lastByte+1, size)
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	return start, end, true
+}
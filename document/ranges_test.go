@@ -0,0 +1,77 @@
+package document
+
+import "testing"
+
+func TestByteRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		ranges   string
+		start    int64
+		end      int64
+		expected string
+		covers   bool
+	}{
+		{"First", "", 0, 4, "0-4", false},
+		{"Disjoint", "0-4", 6, 8, "0-4,6-8", false},
+		{"Adjacent", "0-4", 4, 10, "0-10", true},
+		{"Bridge", "0-4,6-10", 3, 7, "0-10", true},
+		{"Before", "6-10", 0, 2, "0-2,6-10", false},
+		{"Inside", "0-10", 2, 3, "0-10", true},
+		{"Empty", "0-4", 5, 5, "0-4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges := parseByteRanges(tt.ranges).add(tt.start, tt.end)
+			if ranges.String() != tt.expected {
+				t.Fatalf("Expected ranges %s, but got %s", tt.expected, ranges)
+			}
+			if covers := ranges.covers(10); covers != tt.covers {
+				t.Fatalf("Expected covers %v, but got %v", tt.covers, covers)
+			}
+		})
+	}
+}
+
+func TestDeliverFile(t *testing.T) {
+	d := &Document{Files: 2}
+	d.deliverFile(0, 0, 4, 10)
+	if d.FilesDownloaded != 0 || d.DeliveredFiles != `{"0":"0-4"}` {
+		t.Fatalf("Expected a partial file, but got %d files and %s", d.FilesDownloaded, d.DeliveredFiles)
+	}
+	d.deliverFile(0, 4, 10, 10)
+	d.deliverFile(0, 0, 10, 10)
+	d.deliverFile(1, 0, 0, 0)
+	if d.FilesDownloaded != 2 || d.DeliveredFiles != `{"0":"done","1":"done"}` {
+		t.Fatalf("Expected 2 files downloaded once, but got %d files and %s", d.FilesDownloaded, d.DeliveredFiles)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		start  int64
+		end    int64
+		ok     bool
+	}{
+		{"bytes=0-99", 0, 100, true},
+		{"bytes=10-", 10, 1000, true},
+		{"bytes=990-2000", 990, 1000, true},
+		{"bytes=-100", 900, 1000, true},
+		{"bytes=-2000", 0, 1000, true},
+		{"bytes=999-999", 999, 1000, true},
+		{"bytes=1000-", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+		{"bytes=0-1,5-9", 0, 1000, true},
+		{"bytes=50-10", 0, 1000, true},
+		{"bytes=a-b", 0, 1000, true},
+		{"items=0-99", 0, 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			start, end, ok := ParseRange(tt.header, 1000)
+			if start != tt.start || end != tt.end || ok != tt.ok {
+				t.Fatalf("Expected %d-%d (%v), but got %d-%d (%v)", tt.start, tt.end, tt.ok, start, end, ok)
+			}
+		})
+	}
+}
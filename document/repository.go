@@ -5,5 +5,4 @@ type Repository interface {
 	Save(document Document) (Document, error)
 	Update(document Document) (Document, error)
 	GetExpired() ([]Document, error)
-	GetTotalUsage(client string) (int64, error)
 }
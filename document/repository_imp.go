@@ -2,6 +2,7 @@ package document
 
 import (
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"time"
 )
 
@@ -19,6 +20,13 @@ func (r *RepositoryImp) FindById(id string) (*Document, error) {
 	return &d, err
 }
 
+// FindByIdForUpdate locks the document until the end of the transaction.
+func (r *RepositoryImp) FindByIdForUpdate(id string) (*Document, error) {
+	var d Document
+	err := r.Db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&d, "id = ?", id).Error
+	return &d, err
+}
+
 func (r *RepositoryImp) Save(d *Document) error {
 	return r.Db.Create(&d).Error
 }
@@ -29,14 +37,144 @@ func (r *RepositoryImp) Update(d *Document) error {
 
 func (r *RepositoryImp) GetExpired() ([]Document, error) {
 	var documents []Document
-	uploadedBefore := time.Now().Add(-time.Hour * 24)
+	uploadedBefore := time.Now().Add(-ExpiresAfter)
 	err := r.Db.Find(&documents, "status = ? AND uploaded_at < ?", Ready, uploadedBefore).Error
 	return documents, err
 }
 
-func (r *RepositoryImp) GetTotalUsage(client string) (int64, error) {
-	var total int64
-	uploadedBefore := time.Now().Add(-time.Hour)
-	err := r.Db.Model(&Document{}).Where("client = ? AND uploaded_at >= ?", client, uploadedBefore).Count(&total).Error
-	return total, err
+// GetClosedDownloads returns the Downloading documents whose resume window started before the given time.
+func (r *RepositoryImp) GetClosedDownloads(startedBefore time.Time) ([]Document, error) {
+	var documents []Document
+	err := r.Db.Find(&documents, "status = ? AND download_started_at < ?", Downloading, startedBefore).Error
+	return documents, err
+}
+
+func (r *RepositoryImp) FindByOwner(ownerID string) ([]Document, error) {
+	var documents []Document
+	err := r.Db.Order("uploaded_at DESC").Find(&documents, "owner_id = ?", ownerID).Error
+	return documents, err
+}
+
+// GetOwnerUsage returns the number of documents and bytes uploaded by the owner since the given time.
+// The resumable uploads still open count with their whole length, so that they can't each pass the
+// quota before any of them becomes a document.
+func (r *RepositoryImp) GetOwnerUsage(ownerID string, since time.Time) (int64, int64, error) {
+	var documents, uploads struct {
+		Total int64
+		Bytes int64
+	}
+	err := r.Db.Model(&Document{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(file_size), 0) AS bytes").
+		Where("owner_id = ? AND uploaded_at >= ?", ownerID, since).
+		Scan(&documents).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	err = r.Db.Model(&Upload{}).
+		Select("COUNT(*) AS total, COALESCE(SUM(length), 0) AS bytes").
+		Where("owner_id = ?", ownerID).
+		Scan(&uploads).Error
+	return documents.Total + uploads.Total, documents.Bytes + uploads.Bytes, err
+}
+
+func (r *RepositoryImp) FindRequestById(id string) (*UploadRequest, error) {
+	var ur UploadRequest
+	err := r.Db.First(&ur, "id = ?", id).Error
+	return &ur, err
+}
+
+func (r *RepositoryImp) SaveRequest(ur *UploadRequest) error {
+	return r.Db.Create(ur).Error
+}
+
+func (r *RepositoryImp) FindRequestsByOwner(ownerID string) ([]UploadRequest, error) {
+	var requests []UploadRequest
+	err := r.Db.Order("created_at DESC").Find(&requests, "owner_id = ?", ownerID).Error
+	return requests, err
+}
+
+func (r *RepositoryImp) FindByRequest(requestID string) ([]Document, error) {
+	var documents []Document
+	err := r.Db.Order("uploaded_at DESC").Find(&documents, "upload_request_id = ?", requestID).Error
+	return documents, err
+}
+
+// UseRequest counts an upload to the request. It returns gorm.ErrRecordNotFound if the request
+// is no longer open, so that a one-time request can't be used twice by concurrent uploads.
+func (r *RepositoryImp) UseRequest(id string) error {
+	result := r.Db.Model(&UploadRequest{}).
+		Where("id = ? AND closed_at IS NULL AND expires_at > ? AND (one_time = false OR uploads = 0)", id, time.Now()).
+		Update("uploads", gorm.Expr("uploads + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// CloseRequest closes the open request of the owner. It returns gorm.ErrRecordNotFound if there is no such request.
+func (r *RepositoryImp) CloseRequest(ownerID, id string) error {
+	result := r.Db.Model(&UploadRequest{}).
+		Where("id = ? AND owner_id = ? AND closed_at IS NULL", id, ownerID).
+		Update("closed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *RepositoryImp) FindUploadById(id string) (*Upload, error) {
+	var u Upload
+	err := r.Db.First(&u, "id = ?", id).Error
+	return &u, err
+}
+
+// FindUploadByIdForUpdate locks the upload until the end of the transaction.
+func (r *RepositoryImp) FindUploadByIdForUpdate(id string) (*Upload, error) {
+	var u Upload
+	err := r.Db.Clauses(clause.Locking{Strength: "UPDATE"}).First(&u, "id = ?", id).Error
+	return &u, err
+}
+
+func (r *RepositoryImp) SaveUpload(u *Upload) error {
+	return r.Db.Create(u).Error
+}
+
+// AdvanceUpload moves the offset of the upload from one offset to the other. It returns gorm.ErrRecordNotFound
+// if the upload is no longer at offset from, so that concurrent chunks can't both be accepted.
+func (r *RepositoryImp) AdvanceUpload(id string, from, to int64) error {
+	result := r.Db.Model(&Upload{}).
+		Where("id = ? AND \"offset\" = ?", id, from).
+		Updates(map[string]interface{}{"offset": to, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// DeleteUpload deletes the upload. It returns gorm.ErrRecordNotFound if there is no such upload.
+func (r *RepositoryImp) DeleteUpload(id string) error {
+	result := r.Db.Delete(&Upload{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetStaleUploads returns the uploads that received no chunk for StagingExpiresAfter.
+func (r *RepositoryImp) GetStaleUploads() ([]Upload, error) {
+	var uploads []Upload
+	err := r.Db.Find(&uploads, "updated_at < ?", time.Now().Add(-StagingExpiresAfter)).Error
+	return uploads, err
 }
@@ -0,0 +1,220 @@
+package document
+
+import (
+	"dataShare/core"
+	"dataShare/service"
+	"errors"
+	"gorm.io/gorm"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// StagingFolder holds the content of resumable uploads until they are complete.
+	StagingFolder = DataFolder + "staging/"
+	// StagingExpiresAfter is how long a resumable upload is kept after its last chunk before the cleanup job
+	// deletes it.
+	StagingExpiresAfter = 24 * time.Hour
+
+	defaultUploadName = "upload"
+)
+
+func stagingPath(ID string) string {
+	return StagingFolder + ID
+}
+
+// CreateUpload starts a resumable upload of length bytes. The daily quota of the sender and the upload
+// bytes rate limit are checked against the announced length.
+func (h *Handler) CreateUpload(length int64, filename, contentType string) (*Upload, error) {
+	if length <= 0 {
+		return nil, core.NewError(http.StatusBadRequest, 1140, "Upload length must be a positive number of bytes")
+	}
+	if length > MaxUploadFileSize {
+		return nil, core.NewError(http.StatusRequestEntityTooLarge, 1005, "File size is too large")
+	}
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) {
+		filename = defaultUploadName
+	}
+	if len(filename) > 255 || len(contentType) > 255 {
+		return nil, core.NewError(http.StatusBadRequest, 1140, "Upload file name and type must be at most 255 characters")
+	}
+
+	owner, e := h.checkOwner(int(length))
+	if e != nil {
+		return nil, e
+	}
+	if e := h.checkUploadBytes(int(length)); e != nil {
+		return nil, e
+	}
+
+	u := &Upload{
+		ID:          service.NewID("upl"),
+		Client:      h.client(),
+		Filename:    filename,
+		ContentType: contentType,
+		Length:      length,
+	}
+	if owner != nil {
+		u.OwnerID = &owner.ID
+	}
+	if err := os.MkdirAll(StagingFolder, 0700); err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	f, err := os.OpenFile(stagingPath(u.ID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	f.Close()
+	if err := NewRepositoryImp(h.DB).SaveUpload(u); err != nil {
+		os.Remove(stagingPath(u.ID))
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	return u, nil
+}
+
+// Upload returns the resumable upload.
+func (h *Handler) Upload(ID string) (*Upload, error) {
+	u, err := NewRepositoryImp(h.DB).FindUploadById(ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusNotFound, 1150, "Can't find upload")
+	}
+	return u, nil
+}
+
+// WriteUpload appends the chunk read from r to the upload, which must be at offset. The bytes received
+// before the chunk is interrupted are kept, so that the upload can resume from there.
+// The chunk is received into a file of its own, and only appended with the upload locked: a slow client
+// holds neither the lock nor a database connection, and of concurrent chunks for the same offset only
+// the first one is appended.
+func (h *Handler) WriteUpload(ID string, offset int64, r io.Reader) (*Upload, error) {
+	u, err := h.Upload(ID)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return nil, core.NewError(http.StatusConflict, 1160, "Upload offset doesn't match the received bytes")
+	}
+
+	chunk, err := os.CreateTemp(StagingFolder, u.ID+".chunk-")
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	defer os.Remove(chunk.Name())
+	defer chunk.Close()
+	n, copyErr := io.Copy(chunk, io.LimitReader(r, u.Length-offset))
+	if n > 0 {
+		if u, err = h.appendChunk(ID, offset, chunk, n); err != nil {
+			return nil, err
+		}
+	}
+	if copyErr != nil {
+		return u, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	if u.Complete() {
+		if n, _ := r.Read(make([]byte, 1)); n > 0 {
+			return u, core.NewError(http.StatusRequestEntityTooLarge, 1005, "File size is above the length of the upload")
+		}
+	}
+	return u, nil
+}
+
+// appendChunk writes the n bytes of chunk to the staging file of the upload at offset, with the upload
+// locked until its offset is moved past them.
+func (h *Handler) appendChunk(ID string, offset int64, chunk *os.File, n int64) (*Upload, error) {
+	var u *Upload
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		ur := NewRepositoryImp(tx)
+		var err error
+		u, err = ur.FindUploadByIdForUpdate(ID)
+		if err != nil {
+			return core.NewError(http.StatusNotFound, 1150, "Can't find upload")
+		}
+		if offset != u.Offset {
+			return core.NewError(http.StatusConflict, 1160, "Upload offset doesn't match the received bytes")
+		}
+
+		f, err := os.OpenFile(stagingPath(u.ID), os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.NewOffsetWriter(f, offset), io.NewSectionReader(chunk, 0, n))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		err = ur.AdvanceUpload(u.ID, offset, offset+n)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return core.NewError(http.StatusConflict, 1160, "Upload offset doesn't match the received bytes")
+		}
+		if err != nil {
+			return err
+		}
+		u.Offset += n
+		return nil
+	})
+	var coreErr *core.Error
+	if errors.As(err, &coreErr) {
+		return nil, coreErr
+	}
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	return u, nil
+}
+
+// FinishUpload encrypts the complete upload as chosen in the form, like the files of Encrypt, and deletes its staging data.
+func (h *Handler) FinishUpload(ID string, form *multipart.Form) (*core.IDKey, error) {
+	u, err := h.Upload(ID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.Complete() {
+		return nil, core.NewError(http.StatusConflict, 1170, "Upload is not complete")
+	}
+	o, e := parseOptions(form)
+	if e != nil {
+		return nil, e
+	}
+
+	info, err := os.Stat(stagingPath(u.ID))
+	if err != nil || info.Size() != u.Length {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	file := service.NewLocalFile(stagingPath(u.ID), u.Filename, u.ContentType, u.Length)
+	file.Check = h.contentCheck()
+	document := h.newDocument(file)
+	document.Client = u.Client
+	document.OwnerID = u.OwnerID
+
+	// Deleting the upload in the same transaction makes sure it only becomes a single document.
+	idKey, err := h.encryptFile(&document, file, o, func(tx *gorm.DB) error {
+		err := NewRepositoryImp(tx).DeleteUpload(u.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return core.NewError(http.StatusNotFound, 1150, "Can't find upload")
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(stagingPath(u.ID))
+	return idKey, nil
+}
+
+// DeleteUpload cancels the upload and deletes its staging data.
+func (h *Handler) DeleteUpload(ID string) error {
+	if err := NewRepositoryImp(h.DB).DeleteUpload(ID); err != nil {
+		return core.NewError(http.StatusNotFound, 1150, "Can't find upload")
+	}
+	if err := os.Remove(stagingPath(ID)); err != nil && !os.IsNotExist(err) {
+		return core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	return nil
+}
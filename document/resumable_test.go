@@ -0,0 +1,138 @@
+package document
+
+import (
+	"dataShare/account"
+	"dataShare/config"
+	"dataShare/core"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriteUpload(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int64
+		chunk  string
+		// locked is the offset of the upload once it is locked to append the chunk, -1 if it isn't.
+		locked   int64
+		expected string
+		code     int
+	}{
+		{"Resume", 3, "def", 3, "abcdef", 0},
+		{"WrongOffset", 2, "cdef", -1, "abc", 1160},
+		{"TooLarge", 3, "defghi", 3, "abcdef", 1005},
+		{"Concurrent", 3, "xyz", 6, "abc", 1160},
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.MkdirAll(StagingFolder, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(stagingPath("upl_test"), []byte("abc"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			mockDb, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer mockDb.Close()
+			mock.ExpectQuery("SELECT (.+) FROM \"uploads\" WHERE id = (.+)").
+				WithArgs("upl_test").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "length", "offset"}).AddRow("upl_test", 6, 3))
+			if tt.locked >= 0 {
+				mock.ExpectBegin()
+				mock.ExpectQuery("SELECT (.+) FROM \"uploads\" WHERE id = (.+) FOR UPDATE").
+					WithArgs("upl_test").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "length", "offset"}).AddRow("upl_test", 6, tt.locked))
+				if tt.locked == tt.offset {
+					mock.ExpectExec("UPDATE \"uploads\" SET (.+) WHERE id = (.+) AND \"offset\" = (.+)").
+						WithArgs(6, sqlmock.AnyArg(), "upl_test", 3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					mock.ExpectCommit()
+				} else {
+					mock.ExpectRollback()
+				}
+			}
+			db, _ := gorm.Open(postgres.New(postgres.Config{Conn: mockDb, DriverName: "postgres"}), &gorm.Config{})
+
+			h := &Handler{DB: db}
+			u, err := h.WriteUpload("upl_test", tt.offset, strings.NewReader(tt.chunk))
+			var coreErr *core.Error
+			if tt.code != 0 && (!errors.As(err, &coreErr) || coreErr.Code != tt.code) {
+				t.Fatalf("Expected error code %d, but got %v", tt.code, err)
+			}
+			if tt.code == 0 && (err != nil || !u.Complete()) {
+				t.Fatalf("Expected a complete upload, but got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(stagingPath("upl_test"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Expected staged content %q, but got %q", tt.expected, content)
+			}
+			if staged, _ := os.ReadDir(StagingFolder); len(staged) != 1 {
+				t.Fatalf("Expected only the staging file of the upload, but got %d files", len(staged))
+			}
+		})
+	}
+}
+
+func TestCheckOwner_OpenUploads(t *testing.T) {
+	tests := []struct {
+		name    string
+		uploads int64
+		length  int64
+		code    int
+	}{
+		{"NoUpload", 0, 0, 0},
+		{"Uploads", 2, 40, 0},
+		{"TooManyUploads", 3, 60, 1080},
+		{"TooManyBytes", 1, 90, 1080},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDb, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mockDb.Close()
+			mock.ExpectQuery("SELECT COUNT(.+) FROM \"documents\" WHERE owner_id = (.+)").
+				WithArgs("usr_1", sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"total", "bytes"}).AddRow(1, 10))
+			mock.ExpectQuery("SELECT COUNT(.+) FROM \"uploads\" WHERE owner_id = (.+)").
+				WithArgs("usr_1").
+				WillReturnRows(sqlmock.NewRows([]string{"total", "bytes"}).AddRow(tt.uploads, tt.length))
+			db, _ := gorm.Open(postgres.New(postgres.Config{Conn: mockDb, DriverName: "postgres"}), &gorm.Config{})
+
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+			c.Set("config", &config.Config{Accounts: config.Accounts{UploadsPerDay: 4, BytesPerDay: 100}})
+			c.Set("user", &account.User{ID: "usr_1"})
+			h := &Handler{c: c, DB: db}
+			_, e := h.checkOwner(5)
+			if tt.code == 0 && e != nil || tt.code != 0 && (e == nil || e.Code != tt.code) {
+				t.Fatalf("Expected error code %d, but got %v", tt.code, e)
+			}
+		})
+	}
+}
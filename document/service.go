@@ -1,12 +1,16 @@
 package document
 
 import (
+	"errors"
 	"gorm.io/gorm"
+	"log"
 	"os"
 	"time"
 )
 
-func CleanUp(db *gorm.DB) {
+// CleanUp expires the documents that were not downloaded in time, deletes those whose resume window
+// closed, and the staging data of abandoned resumable uploads.
+func CleanUp(db *gorm.DB, resumeWindow time.Duration) {
 	repo := NewRepositoryImp(db)
 	documents, err := repo.GetExpired()
 	if err != nil {
@@ -20,9 +24,61 @@ func CleanUp(db *gorm.DB) {
 		if err != nil {
 			panic(err)
 		}
-		err = os.Remove(dataFolder + d.ID)
+		err = os.Remove(DataFolder + d.ID)
 		if err != nil {
 			panic(err)
 		}
 	}
+	downloads, err := repo.GetClosedDownloads(time.Now().Add(-resumeWindow))
+	if err != nil {
+		panic(err)
+	}
+	for _, d := range downloads {
+		now := time.Now()
+		d.Status = Downloaded
+		d.DownloadedAt = &now
+		d.UpdatedAt = &now
+		d.ResumeKeyHash = ""
+		if err := repo.Update(&d); err != nil {
+			panic(err)
+		}
+		if err := os.Remove(DataFolder + d.ID); err != nil && !os.IsNotExist(err) {
+			panic(err)
+		}
+	}
+	cleanUpStaging(repo)
+}
+
+// cleanUpStaging deletes the resumable uploads abandoned for StagingExpiresAfter, and the staging files
+// left without an upload.
+func cleanUpStaging(repo *RepositoryImp) {
+	uploads, err := repo.GetStaleUploads()
+	if err != nil {
+		panic(err)
+	}
+	for _, u := range uploads {
+		if err := repo.DeleteUpload(u.ID); err != nil {
+			continue
+		}
+		if err := os.Remove(stagingPath(u.ID)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Can't remove staging file of upload %s: %s", u.ID, err)
+		}
+	}
+
+	entries, err := os.ReadDir(StagingFolder)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < StagingExpiresAfter {
+			continue
+		}
+		if _, err := repo.FindUploadById(entry.Name()); !errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err := os.Remove(stagingPath(entry.Name())); err != nil && !os.IsNotExist(err) {
+			log.Printf("Can't remove staging file %s: %s", entry.Name(), err)
+		}
+	}
 }
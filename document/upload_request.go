@@ -0,0 +1,190 @@
+package document
+
+import (
+	"dataShare/account"
+	"dataShare/core"
+	"dataShare/service"
+	"errors"
+	"gorm.io/gorm"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	maxRequestTitle = 255
+	minRequestTTL   = time.Hour
+	maxRequestTTL   = 30 * 24 * time.Hour
+)
+
+// RequestUpload is a document uploaded through an upload request together with its key.
+// Key is empty when it can't be unsealed.
+type RequestUpload struct {
+	Document Document
+	Key      string
+}
+
+// CreateRequest opens an upload request for u. The returned secret unseals the keys of the uploaded
+// documents; it is not stored and can't be recovered later.
+func (h *Handler) CreateRequest(u *account.User, title string, maxSize int64, ttl time.Duration, oneTime bool) (*UploadRequest, string, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || len(title) > maxRequestTitle {
+		return nil, "", core.NewError(http.StatusBadRequest, 5000, "Title must be between 1 and 255 characters")
+	}
+	if maxSize <= 0 || maxSize > MaxUploadFileSize {
+		return nil, "", core.NewError(http.StatusBadRequest, 5010, "Maximum size must be between 1 byte and 100 MiB")
+	}
+	if ttl < minRequestTTL || ttl > maxRequestTTL {
+		return nil, "", core.NewError(http.StatusBadRequest, 5020, "Expiry must be between 1 hour and 30 days")
+	}
+
+	secret, publicKey, err := service.GenerateSealKey()
+	if err != nil {
+		return nil, "", core.NewError(http.StatusUnprocessableEntity, 5030, "Can't create upload request")
+	}
+	now := time.Now()
+	r := &UploadRequest{
+		ID:        service.NewID("req"),
+		OwnerID:   u.ID,
+		Title:     title,
+		PublicKey: publicKey,
+		MaxSize:   maxSize,
+		OneTime:   oneTime,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := NewRepositoryImp(h.DB).SaveRequest(r); err != nil {
+		return nil, "", core.NewError(http.StatusUnprocessableEntity, 5030, "Can't create upload request")
+	}
+	return r, secret, nil
+}
+
+// Request returns the upload request if it still accepts uploads.
+func (h *Handler) Request(ID string) (*UploadRequest, error) {
+	r, err := NewRepositoryImp(h.DB).FindRequestById(ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusNotFound, 5040, "Can't find upload request")
+	}
+	if !r.Open() {
+		return nil, core.NewError(http.StatusGone, 5050, "Upload request is closed")
+	}
+	return r, nil
+}
+
+// EncryptForRequest uploads the files of the form to the upload request. The uploader gets neither the
+// link nor the key: the key is sealed to the request so that only its owner can read it.
+func (h *Handler) EncryptForRequest(ID string, form *multipart.Form) error {
+	r, err := h.Request(ID)
+	if err != nil {
+		return err
+	}
+
+	files, e := h.validateFiles(form)
+	if e != nil {
+		return e
+	}
+	if int64(getTotalFileSize(files)) > r.MaxSize {
+		return core.NewError(http.StatusBadRequest, 5060, "File size is above the limit of the upload request")
+	}
+	archive, e := h.archive(form)
+	if e != nil {
+		return e
+	}
+	if e := h.checkUploadBytes(getTotalFileSize(files)); e != nil {
+		return e
+	}
+
+	file := service.GetFileFromFileHeader(files, form.Value["paths"], archive)
+	file.Check = h.contentCheck()
+
+	passphrase, keyFormat, err := h.newKey()
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	sealedKey, err := service.Seal(r.PublicKey, []byte(passphrase))
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	document := h.newDocument(file)
+	document.KeyFormat = keyFormat
+	document.OwnerID = &r.OwnerID
+	document.UploadRequestID = &r.ID
+	document.SealedKey = sealedKey
+
+	document.Format = FormatStream
+	sealer, err := h.e.NewStreamKey(passphrase, "")
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	err = h.store(&document, file, sealer.Encrypt, sealer, func(tx *gorm.DB) error {
+		return NewRepositoryImp(tx).UseRequest(r.ID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return core.NewError(http.StatusGone, 5050, "Upload request is closed")
+	}
+	var coreErr *core.Error
+	if errors.As(err, &coreErr) {
+		return coreErr
+	}
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 1030, "Can't process file")
+	}
+	return nil
+}
+
+// Requests lists the upload requests of u, the latest first.
+func (h *Handler) Requests(u *account.User) ([]UploadRequest, error) {
+	requests, err := NewRepositoryImp(h.DB).FindRequestsByOwner(u.ID)
+	if err != nil {
+		return nil, core.NewError(http.StatusUnprocessableEntity, 5070, "Can't get upload requests")
+	}
+	return requests, nil
+}
+
+// OwnedRequest returns the upload request of u, without its uploads.
+func (h *Handler) OwnedRequest(u *account.User, ID string) (*UploadRequest, error) {
+	r, err := NewRepositoryImp(h.DB).FindRequestById(ID)
+	if err != nil || r.OwnerID != u.ID {
+		return nil, core.NewError(http.StatusNotFound, 5040, "Can't find upload request")
+	}
+	return r, nil
+}
+
+// RequestUploads returns the upload request of u with the documents uploaded to it, their keys
+// unsealed with secret.
+func (h *Handler) RequestUploads(u *account.User, ID, secret string) (*UploadRequest, []RequestUpload, error) {
+	r, err := h.OwnedRequest(u, ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if publicKey, err := service.SealPublicKey(secret); err != nil || publicKey != r.PublicKey {
+		return nil, nil, core.NewError(http.StatusNotFound, 5040, "Can't find upload request")
+	}
+
+	documents, err := NewRepositoryImp(h.DB).FindByRequest(r.ID)
+	if err != nil {
+		return nil, nil, core.NewError(http.StatusUnprocessableEntity, 5070, "Can't get upload requests")
+	}
+	uploads := make([]RequestUpload, 0, len(documents))
+	for _, d := range documents {
+		upload := RequestUpload{Document: d}
+		if key, err := service.Open(secret, d.SealedKey); err == nil {
+			upload.Key = string(key)
+		}
+		uploads = append(uploads, upload)
+	}
+	return r, uploads, nil
+}
+
+// CloseRequest stops the upload request of u from accepting uploads.
+func (h *Handler) CloseRequest(u *account.User, ID string) error {
+	err := NewRepositoryImp(h.DB).CloseRequest(u.ID, ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return core.NewError(http.StatusNotFound, 5040, "Can't find upload request")
+	}
+	if err != nil {
+		return core.NewError(http.StatusUnprocessableEntity, 5080, "Can't close upload request")
+	}
+	return nil
+}
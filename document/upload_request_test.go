@@ -0,0 +1,83 @@
+package document
+
+import (
+	"dataShare/account"
+	"dataShare/core"
+	"dataShare/service"
+	"errors"
+	"gorm.io/gorm/schema"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRequestStatusName(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		request  UploadRequest
+		expected string
+		open     bool
+	}{
+		{"Open", UploadRequest{ExpiresAt: now.Add(time.Hour)}, "Open", true},
+		{"OpenReused", UploadRequest{ExpiresAt: now.Add(time.Hour), Uploads: 3}, "Open", true},
+		{"Used", UploadRequest{ExpiresAt: now.Add(time.Hour), OneTime: true, Uploads: 1}, "Used", false},
+		{"Expired", UploadRequest{ExpiresAt: now.Add(-time.Hour)}, "Expired", false},
+		{"Closed", UploadRequest{ExpiresAt: now.Add(time.Hour), ClosedAt: &now}, "Closed", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status := RequestStatusName(&tt.request); status != tt.expected {
+				t.Fatalf("Expected status %s, but got %s", tt.expected, status)
+			}
+			if open := tt.request.Open(); open != tt.open {
+				t.Fatalf("Expected open %v, but got %v", tt.open, open)
+			}
+		})
+	}
+}
+
+func TestCreateRequest_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		title   string
+		maxSize int64
+		ttl     time.Duration
+		code    int
+	}{
+		{"NoTitle", " ", 1 << 20, 24 * time.Hour, 5000},
+		{"TooLarge", "Invoices", MaxUploadFileSize + 1, 24 * time.Hour, 5010},
+		{"TooShort", "Invoices", 1 << 20, time.Second, 5020},
+		{"TooLong", "Invoices", 1 << 20, 31 * 24 * time.Hour, 5020},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := (&Handler{}).CreateRequest(&account.User{ID: "usr_1"}, tt.title, tt.maxSize, tt.ttl, false)
+			var coreErr *core.Error
+			if !errors.As(err, &coreErr) || coreErr.Code != tt.code {
+				t.Fatalf("Expected error %d, but got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestSealedKey_WordKey(t *testing.T) {
+	_, publicKey, err := service.GenerateSealKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The longest key: 20 words, the most keys.words allows, of 9 letters, the longest of the wordlist.
+	key := strings.TrimSuffix(strings.Repeat("abcdefghi-", 20), "-")
+	sealed, err := service.Seal(publicKey, []byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := schema.Parse(&Document{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := s.LookUpField("SealedKey").Size; size != 0 && len(sealed) > size {
+		t.Fatalf("Expected the sealed key of %d characters to fit in the column of %d", len(sealed), size)
+	}
+}
@@ -3,16 +3,23 @@ module dataShare
 go 1.21
 
 require (
+	filippo.io/age v1.1.1
 	github.com/DATA-DOG/go-sqlmock v1.5.1
+	github.com/coreos/go-oidc/v3 v3.9.0
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.17.4
 	github.com/labstack/echo/v4 v4.11.4
 	golang.org/x/crypto v0.17.0
+	golang.org/x/oauth2 v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/postgres v1.5.4
 	gorm.io/gorm v1.25.5
 )
 
 require (
+	github.com/go-jose/go-jose/v3 v3.0.1 // indirect
 	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
 	github.com/jackc/pgx/v5 v5.4.3 // indirect
@@ -27,4 +34,6 @@ require (
 	golang.org/x/sys v0.15.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
+	google.golang.org/appengine v1.6.8 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
 )
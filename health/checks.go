@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"os"
+	"time"
+)
+
+// DatabaseCheck pings the database through the gorm connection pool.
+func DatabaseCheck(db *gorm.DB) Check {
+	return func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return fmt.Errorf("can't get connection pool: %w", err)
+		}
+		if err := sqlDB.PingContext(ctx); err != nil {
+			return fmt.Errorf("database unreachable: %w", err)
+		}
+		return nil
+	}
+}
+
+// StorageCheck verifies that dir is writable and has at least minFree bytes available.
+func StorageCheck(dir string, minFree uint64) Check {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return fmt.Errorf("storage not writable: %w", err)
+		}
+		name := f.Name()
+		_, err = f.Write([]byte("ok"))
+		closeErr := f.Close()
+		removeErr := os.Remove(name)
+		if err = errors.Join(err, closeErr, removeErr); err != nil {
+			return fmt.Errorf("storage not writable: %w", err)
+		}
+
+		free, err := freeSpace(dir)
+		if err != nil {
+			return fmt.Errorf("can't get free space: %w", err)
+		}
+		if free < minFree {
+			return fmt.Errorf("free space %d bytes is below threshold of %d bytes", free, minFree)
+		}
+		return nil
+	}
+}
+
+// HeartbeatCheck fails when the job behind hb has not completed within maxAge.
+func HeartbeatCheck(hb *Heartbeat, maxAge time.Duration) Check {
+	return func(ctx context.Context) error {
+		last := hb.Last()
+		if last.IsZero() {
+			return errors.New("job has not run yet")
+		}
+		if age := time.Since(last); age > maxAge {
+			return fmt.Errorf("job last ran %s ago, more than %s", age.Truncate(time.Second), maxAge)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChecker_Run(t *testing.T) {
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		status string
+	}{
+		{
+			name:   "NoChecks",
+			checks: map[string]Check{},
+			status: StatusOK,
+		},
+		{
+			name: "AllPassing",
+			checks: map[string]Check{
+				"a": func(ctx context.Context) error { return nil },
+				"b": func(ctx context.Context) error { return nil },
+			},
+			status: StatusOK,
+		},
+		{
+			name: "OneFailing",
+			checks: map[string]Check{
+				"a": func(ctx context.Context) error { return nil },
+				"b": func(ctx context.Context) error { return errors.New("broken") },
+			},
+			status: StatusFail,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(time.Second)
+			for name, check := range tt.checks {
+				c.Add(name, check)
+			}
+			report := c.Run(context.Background())
+			if report.Status != tt.status {
+				t.Fatalf("Expected status '%s', but got '%s'", tt.status, report.Status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("Expected %d check results, but got %d", len(tt.checks), len(report.Checks))
+			}
+			if r, ok := report.Checks["b"]; ok && r.Status == StatusFail && r.Error != "broken" {
+				t.Fatalf("Expected error 'broken', but got '%s'", r.Error)
+			}
+		})
+	}
+}
+
+func TestStorageCheck(t *testing.T) {
+	dir := t.TempDir()
+	if err := StorageCheck(dir, 0)(context.Background()); err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	if err := StorageCheck(dir, ^uint64(0))(context.Background()); err == nil {
+		t.Fatal("Expected free space error, but got nothing")
+	}
+	if err := StorageCheck(dir+"/missing", 0)(context.Background()); err == nil {
+		t.Fatal("Expected not writable error, but got nothing")
+	}
+}
+
+func TestHeartbeatCheck(t *testing.T) {
+	hb := &Heartbeat{}
+	check := HeartbeatCheck(hb, time.Minute)
+	if err := check(context.Background()); err == nil {
+		t.Fatal("Expected error for a job that never ran, but got nothing")
+	}
+	hb.Beat()
+	if err := check(context.Background()); err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	hb.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if err := check(context.Background()); err == nil {
+		t.Fatal("Expected error for a stale job, but got nothing")
+	}
+}
@@ -0,0 +1,94 @@
+package pow
+
+import (
+	"dataShare/core"
+	"dataShare/ratelimit"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"math"
+	"net/http"
+)
+
+// Scopes a challenge can be issued for.
+const (
+	ScopeUpload   = "upload"
+	ScopeDownload = "download"
+)
+
+// DifficultyFunc returns the difficulty of the next challenge of scope for the client of c.
+type DifficultyFunc func(c echo.Context, scope string) int
+
+// LimiterDifficulty raises the difficulty from base up to max as the client uses up the rate limit
+// policy mapped to the scope, so clients close to their limits pay more for each request.
+func LimiterDifficulty(l *ratelimit.Limiter, policies map[string]string, base, max int) DifficultyFunc {
+	return func(c echo.Context, scope string) int {
+		policy, ok := policies[scope]
+		if !ok {
+			return base
+		}
+		client, _ := c.Get("client").(string)
+		r, err := l.Peek(c.Request().Context(), policy, client)
+		if err != nil || r.Limit <= 0 {
+			return base
+		}
+		used := 1 - float64(r.Remaining)/float64(r.Limit)
+		return base + int(math.Round(used*float64(max-base)))
+	}
+}
+
+type challengeResponse struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+	ExpiresAt  int64  `json:"expires_at"`
+}
+
+// ChallengeHandler issues a challenge for the scope given in the "scope" query parameter.
+func ChallengeHandler(i *Issuer, difficulty DifficultyFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		scope := c.QueryParam("scope")
+		if scope != ScopeUpload && scope != ScopeDownload {
+			return c.JSON(http.StatusBadRequest, core.NewError(http.StatusBadRequest, 3000, "Unknown proof of work scope"))
+		}
+		client, _ := c.Get("client").(string)
+		token, ch, err := i.Issue(scope, client, difficulty(c, scope))
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.JSON(http.StatusOK, challengeResponse{
+			Challenge:  token,
+			Difficulty: ch.Difficulty,
+			ExpiresAt:  ch.ExpiresAt,
+		})
+	}
+}
+
+// Middleware rejects requests without a valid solution for a challenge of scope issued to the client.
+// The challenge and solution are read from the pow_challenge and pow_solution form fields or the
+// X-PoW-Challenge and X-PoW-Solution headers. Challenges easier than the difficulty the client is at
+// now are rejected too, so that challenges collected far from the rate limits can't be spent close to them.
+func Middleware(i *Issuer, scope string, difficulty DifficultyFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token, solution := c.Request().Header.Get("X-PoW-Challenge"), c.Request().Header.Get("X-PoW-Solution")
+			if token == "" {
+				token, solution = c.FormValue("pow_challenge"), c.FormValue("pow_solution")
+			}
+			if token == "" || solution == "" {
+				return core.NewError(http.StatusForbidden, 3010, "Proof of work is missing, enable JavaScript and try again")
+			}
+			client, _ := c.Get("client").(string)
+			ch, err := i.Verify(token, solution, scope, client)
+			if err != nil {
+				if errors.Is(err, ErrExpired) {
+					return core.NewError(http.StatusForbidden, 3030, "Proof of work expired, try again")
+				}
+				return core.NewError(http.StatusForbidden, 3020, "Proof of work is invalid")
+			}
+			if ch.Difficulty < difficulty(c, scope) {
+				return core.NewError(http.StatusForbidden, 3040, "Proof of work is outdated, try again")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalid = errors.New("invalid proof of work challenge")
+	ErrExpired = errors.New("proof of work challenge expired")
+	ErrReused  = errors.New("proof of work challenge already used")
+	ErrScope   = errors.New("proof of work challenge issued for another action")
+	ErrClient  = errors.New("proof of work challenge issued for another client")
+	ErrNoMatch = errors.New("proof of work solution does not match")
+)
+
+// Challenge is the signed payload sent to the client. The client must find a solution such that
+// SHA-256(token + ":" + solution) starts with Difficulty zero bits.
+type Challenge struct {
+	Nonce string `json:"n"`
+	Scope string `json:"s"`
+	// Client is the keyed hash of the client the challenge is issued to, only it can spend it.
+	Client     string `json:"c"`
+	Difficulty int    `json:"d"`
+	ExpiresAt  int64  `json:"e"`
+}
+
+// Issuer signs challenges with an HMAC key and verifies each of them at most once.
+type Issuer struct {
+	secret []byte
+	ttl    time.Duration
+
+	mu   sync.Mutex
+	used map[string]time.Time
+	now  func() time.Time
+}
+
+func NewIssuer(secret []byte, ttl time.Duration) *Issuer {
+	return &Issuer{secret: secret, ttl: ttl, used: make(map[string]time.Time), now: time.Now}
+}
+
+func (i *Issuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a new signed challenge token for scope, to be solved by client.
+func (i *Issuer) Issue(scope, client string, difficulty int) (string, Challenge, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", Challenge{}, err
+	}
+	ch := Challenge{
+		Nonce:      hex.EncodeToString(nonce),
+		Scope:      scope,
+		Client:     i.sign("client:" + client),
+		Difficulty: difficulty,
+		ExpiresAt:  i.now().Add(i.ttl).Unix(),
+	}
+	content, err := json.Marshal(ch)
+	if err != nil {
+		return "", Challenge{}, err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(content)
+	return payload + "." + i.sign(payload), ch, nil
+}
+
+// Verify checks the signature, scope, client, expiry and solution of token, then marks it as used.
+// It returns the verified challenge.
+func (i *Issuer) Verify(token, solution, scope, client string) (Challenge, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(i.sign(payload))) {
+		return Challenge{}, ErrInvalid
+	}
+	content, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return Challenge{}, ErrInvalid
+	}
+	var ch Challenge
+	if err := json.Unmarshal(content, &ch); err != nil {
+		return Challenge{}, ErrInvalid
+	}
+	if ch.Scope != scope {
+		return Challenge{}, ErrScope
+	}
+	if !hmac.Equal([]byte(ch.Client), []byte(i.sign("client:"+client))) {
+		return Challenge{}, ErrClient
+	}
+	now := i.now()
+	if now.Unix() > ch.ExpiresAt {
+		return Challenge{}, ErrExpired
+	}
+	if !Valid(token, solution, ch.Difficulty) {
+		return Challenge{}, ErrNoMatch
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, ok := i.used[ch.Nonce]; ok {
+		return Challenge{}, ErrReused
+	}
+	i.used[ch.Nonce] = time.Unix(ch.ExpiresAt, 0)
+	return ch, nil
+}
+
+// Prune forgets used challenges that have expired, they can't be replayed anymore.
+func (i *Issuer) Prune() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	now := i.now()
+	for nonce, expiresAt := range i.used {
+		if now.After(expiresAt) {
+			delete(i.used, nonce)
+		}
+	}
+}
+
+// leadingZeroBits counts the zero bits at the start of b.
+func leadingZeroBits(b []byte) int {
+	n := 0
+	for _, v := range b {
+		if v != 0 {
+			return n + bits.LeadingZeros8(v)
+		}
+		n += 8
+	}
+	return n
+}
+
+// Valid reports whether solution solves token at the given difficulty.
+func Valid(token, solution string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(token + ":" + solution))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+// Solve finds a solution for token by brute force. It is meant for command line clients.
+func Solve(token string, difficulty int) string {
+	for n := 0; ; n++ {
+		solution := strconv.Itoa(n)
+		if Valid(token, solution, difficulty) {
+			return solution
+		}
+	}
+}
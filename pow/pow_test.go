@@ -0,0 +1,150 @@
+package pow
+
+import (
+	"context"
+	"dataShare/core"
+	"dataShare/ratelimit"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssuer_Verify(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	i := NewIssuer([]byte("secret"), time.Minute)
+	i.now = func() time.Time { return now }
+
+	token, ch, err := i.Issue(ScopeUpload, "client", 8)
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	if ch.Difficulty != 8 || ch.Scope != ScopeUpload {
+		t.Fatalf("Unexpected challenge %+v", ch)
+	}
+	solution := Solve(token, 8)
+
+	other := NewIssuer([]byte("other"), time.Minute)
+	tests := []struct {
+		name     string
+		issuer   *Issuer
+		token    string
+		solution string
+		scope    string
+		client   string
+		err      error
+	}{
+		{"WrongSecret", other, token, solution, ScopeUpload, "client", ErrInvalid},
+		{"Tampered", i, "x" + token, solution, ScopeUpload, "client", ErrInvalid},
+		{"WrongScope", i, token, solution, ScopeDownload, "client", ErrScope},
+		{"OtherClient", i, token, solution, ScopeUpload, "other", ErrClient},
+		{"WrongSolution", i, token, solution + "x", ScopeUpload, "client", ErrNoMatch},
+		{"Valid", i, token, solution, ScopeUpload, "client", nil},
+		{"Reused", i, token, solution, ScopeUpload, "client", ErrReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.issuer.Verify(tt.token, tt.solution, tt.scope, tt.client); !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error '%v', but got '%v'", tt.err, err)
+			}
+		})
+	}
+
+	token, _, _ = i.Issue(ScopeUpload, "client", 1)
+	now = now.Add(2 * time.Minute)
+	if _, err := i.Verify(token, Solve(token, 1), ScopeUpload, "client"); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Expected error '%v', but got '%v'", ErrExpired, err)
+	}
+
+	i.Prune()
+	if len(i.used) != 0 {
+		t.Fatal("Expected Prune to forget expired challenges")
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		b        []byte
+		expected int
+	}{
+		{[]byte{0xff}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0x10}, 11},
+		{[]byte{0x00, 0x00}, 16},
+	}
+	for _, tt := range tests {
+		if got := leadingZeroBits(tt.b); got != tt.expected {
+			t.Fatalf("leadingZeroBits(%x): expected %d, but got %d", tt.b, tt.expected, got)
+		}
+	}
+}
+
+func TestLimiterDifficulty(t *testing.T) {
+	policy := ratelimit.Policy{Name: ratelimit.PolicyUpload, Capacity: 4, Period: time.Hour}
+	l := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), policy)
+	difficulty := LimiterDifficulty(l, map[string]string{ScopeUpload: ratelimit.PolicyUpload}, 10, 18)
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+	c.Set("client", "hash")
+
+	expected := []int{10, 12, 14, 16, 18}
+	for n, d := range expected {
+		if got := difficulty(c, ScopeUpload); got != d {
+			t.Fatalf("After %d uploads: expected difficulty %d, but got %d", n, d, got)
+		}
+		l.Allow(context.Background(), ratelimit.PolicyUpload, "hash", 1)
+	}
+	if got := difficulty(c, ScopeDownload); got != 10 {
+		t.Fatalf("Expected base difficulty for unmapped scope, but got %d", got)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	i := NewIssuer([]byte("secret"), time.Minute)
+	solved := func(client string, difficulty int) url.Values {
+		token, _, err := i.Issue(ScopeDownload, client, difficulty)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return url.Values{"pow_challenge": {token}, "pow_solution": {Solve(token, difficulty)}, "export": {"true"}}
+	}
+	export := solved("client", 4)
+	difficulty := func(c echo.Context, scope string) int { return 4 }
+
+	tests := []struct {
+		name string
+		form url.Values
+		code int
+	}{
+		{"Missing", url.Values{"export": {"true"}}, 3010},
+		// The export button is sent with the solved challenge, as pow.js adds it to the form.
+		{"Export", export, 0},
+		{"Reused", export, 3020},
+		{"OtherClient", solved("other", 4), 3020},
+		{"TooEasy", solved("client", 2), 3040},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/doc_1", strings.NewReader(tt.form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+			c.Set("client", "client")
+			export := ""
+			err := Middleware(i, ScopeDownload, difficulty)(func(c echo.Context) error {
+				export = c.FormValue("export")
+				return nil
+			})(c)
+			var coreErr *core.Error
+			if errors.As(err, &coreErr) && coreErr.Code != tt.code || err != nil && coreErr == nil || err == nil && tt.code != 0 {
+				t.Fatalf("Expected error code %d, but got %v", tt.code, err)
+			}
+			if tt.code == 0 && export != "true" {
+				t.Fatalf("Expected the export field, but got %q", export)
+			}
+		})
+	}
+}
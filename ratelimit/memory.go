@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const pruneEvery = 1000
+
+// MemoryStore keeps buckets in process memory. It is only accurate with a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]memoryBucket
+	ops     int
+	now     func() time.Time
+}
+
+type memoryBucket struct {
+	Bucket
+	period time.Duration
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]memoryBucket), now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, p Policy, cost float64) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops++
+	if s.ops%pruneEvery == 0 {
+		s.prune()
+	}
+
+	var current *Bucket
+	if b, ok := s.buckets[key]; ok {
+		current = &b.Bucket
+	}
+	state, result := take(current, p, cost, s.now())
+	s.buckets[key] = memoryBucket{Bucket: state, period: p.Period}
+	return result, nil
+}
+
+func (s *MemoryStore) Peek(ctx context.Context, key string, p Policy) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *Bucket
+	if b, ok := s.buckets[key]; ok {
+		current = &b.Bucket
+	}
+	_, result := take(current, p, 0, s.now())
+	return result, nil
+}
+
+// prune drops buckets that have had time to refill completely, they are equal to a new bucket.
+func (s *MemoryStore) prune() {
+	now := s.now()
+	for key, b := range s.buckets {
+		if now.Sub(b.UpdatedAt) >= b.period {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"dataShare/core"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+// SetHeaders writes the RateLimit-* headers, and Retry-After when the request was denied.
+func SetHeaders(c echo.Context, r Result) {
+	h := c.Response().Header()
+	h.Set("RateLimit-Limit", strconv.FormatInt(r.Limit, 10))
+	h.Set("RateLimit-Remaining", strconv.FormatInt(r.Remaining, 10))
+	h.Set("RateLimit-Reset", strconv.FormatInt(int64(r.Reset.Seconds()), 10))
+	if !r.Allowed {
+		h.Set("Retry-After", strconv.FormatInt(int64(r.RetryAfter.Seconds()), 10))
+	}
+}
+
+// Deny returns the error for a request rejected by p.
+func Deny(p Policy) *core.Error {
+	return core.NewError(http.StatusTooManyRequests, p.ErrCode, p.ErrMsg)
+}
+
+// Check consumes cost tokens of policy for the client of the request and sets the response headers.
+// It returns a *core.Error when the request is over the limit.
+func (l *Limiter) Check(c echo.Context, policy string, cost float64) error {
+	client, _ := c.Get("client").(string)
+	if client == "" {
+		client = c.RealIP()
+	}
+	result, err := l.Allow(c.Request().Context(), policy, client, cost)
+	if err != nil {
+		return err
+	}
+	SetHeaders(c, result)
+	if !result.Allowed {
+		p, _ := l.Policy(policy)
+		return Deny(p)
+	}
+	return nil
+}
+
+// Middleware applies policy to every request of the route, one token per request.
+func Middleware(l *Limiter, policy string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if err := l.Check(c, policy, 1); err != nil {
+				return err
+			}
+			return next(c)
+		}
+	}
+}
+
+// ContextLimiter makes the limiter available to handlers under the "ratelimiter" key.
+func ContextLimiter(l *Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("ratelimiter", l)
+			return next(c)
+		}
+	}
+}
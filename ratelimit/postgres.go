@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// RateLimitBucket is the table backing PostgresStore.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey;size:255"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null;index"`
+}
+
+// PostgresStore shares buckets between instances through the database.
+// Each Take locks the bucket row for the duration of its transaction.
+type PostgresStore struct {
+	Db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{Db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, p Policy, cost float64) (Result, error) {
+	var result Result
+	err := s.Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists so it can be locked.
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&RateLimitBucket{Key: key, Tokens: p.Capacity, UpdatedAt: time.Now()}).Error
+		if err != nil {
+			return err
+		}
+
+		var row RateLimitBucket
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, "key = ?", key).Error
+		if err != nil {
+			return err
+		}
+
+		var state Bucket
+		state, result = take(&Bucket{Tokens: row.Tokens, UpdatedAt: row.UpdatedAt}, p, cost, time.Now())
+		row.Tokens, row.UpdatedAt = state.Tokens, state.UpdatedAt
+		return tx.Save(&row).Error
+	})
+	return result, err
+}
+
+func (s *PostgresStore) Peek(ctx context.Context, key string, p Policy) (Result, error) {
+	var row RateLimitBucket
+	err := s.Db.WithContext(ctx).First(&row, "key = ?", key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_, result := take(nil, p, 0, time.Now())
+		return result, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	_, result := take(&Bucket{Tokens: row.Tokens, UpdatedAt: row.UpdatedAt}, p, 0, time.Now())
+	return result, nil
+}
+
+// Prune deletes buckets untouched for longer than maxPeriod, they are full again by now.
+func (s *PostgresStore) Prune(maxPeriod time.Duration) error {
+	return s.Db.Where("updated_at < ?", time.Now().Add(-maxPeriod)).Delete(&RateLimitBucket{}).Error
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Names of the policies applied by the application.
+const (
+	PolicyUpload      = "upload"
+	PolicyUploadBytes = "upload_bytes"
+	PolicyDownload    = "download"
+	PolicyCheck       = "check"
+	PolicyLogin       = "login"
+	PolicyChallenge   = "challenge"
+)
+
+// Policy is a token bucket holding up to Capacity tokens, refilled completely every Period.
+type Policy struct {
+	Name     string
+	Capacity float64
+	Period   time.Duration
+	// ErrCode and ErrMsg describe the core.Error returned when the policy denies a request.
+	ErrCode int
+	ErrMsg  string
+}
+
+func (p Policy) rate() float64 {
+	return p.Capacity / p.Period.Seconds()
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      time.Duration // time until the bucket is full again
+	RetryAfter time.Duration // time until the denied cost would be allowed, zero when allowed
+}
+
+// Bucket is the persisted state of a token bucket.
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+// Store persists buckets. Take must refill, check and consume atomically.
+type Store interface {
+	Take(ctx context.Context, key string, p Policy, cost float64) (Result, error)
+	Peek(ctx context.Context, key string, p Policy) (Result, error)
+}
+
+// take applies the token bucket algorithm: refill b up to now, then consume cost if available.
+// A nil bucket is a new, full bucket.
+func take(b *Bucket, p Policy, cost float64, now time.Time) (Bucket, Result) {
+	state := Bucket{Tokens: p.Capacity, UpdatedAt: now}
+	if b != nil {
+		elapsed := now.Sub(b.UpdatedAt).Seconds()
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		state.Tokens = math.Min(p.Capacity, b.Tokens+elapsed*p.rate())
+	}
+
+	result := Result{Limit: int64(p.Capacity)}
+	if cost <= state.Tokens {
+		state.Tokens -= cost
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((cost - state.Tokens) / p.rate())
+	}
+	result.Remaining = int64(math.Floor(state.Tokens))
+	result.Reset = seconds((p.Capacity - state.Tokens) / p.rate())
+	return state, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s)) * time.Second
+}
+
+// Limiter applies named policies on top of a Store.
+type Limiter struct {
+	store    Store
+	policies map[string]Policy
+}
+
+func NewLimiter(store Store, policies ...Policy) *Limiter {
+	l := &Limiter{store: store, policies: make(map[string]Policy, len(policies))}
+	for _, p := range policies {
+		l.policies[p.Name] = p
+	}
+	return l
+}
+
+func (l *Limiter) Policy(name string) (Policy, error) {
+	p, ok := l.policies[name]
+	if !ok {
+		return Policy{}, fmt.Errorf("unknown rate limit policy %q", name)
+	}
+	return p, nil
+}
+
+// Allow consumes cost tokens of the named policy for key.
+func (l *Limiter) Allow(ctx context.Context, policy, key string, cost float64) (Result, error) {
+	p, err := l.Policy(policy)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.store.Take(ctx, p.Name+":"+key, p, cost)
+}
+
+// Peek returns the state of the named policy for key without consuming tokens.
+func (l *Limiter) Peek(ctx context.Context, policy, key string) (Result, error) {
+	p, err := l.Policy(policy)
+	if err != nil {
+		return Result{}, err
+	}
+	return l.store.Peek(ctx, p.Name+":"+key, p)
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"dataShare/core"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{Name: "test", Capacity: 3, Period: 3 * time.Minute, ErrCode: 9000, ErrMsg: "slow down"}
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		r, _ := s.Take(ctx, "k", testPolicy, 1)
+		if !r.Allowed || r.Remaining != int64(i) {
+			t.Fatalf("Expected allowed with %d remaining, but got %+v", i, r)
+		}
+	}
+	r, _ := s.Take(ctx, "k", testPolicy, 1)
+	if r.Allowed {
+		t.Fatal("Expected empty bucket to deny")
+	}
+	if r.RetryAfter != time.Minute {
+		t.Fatalf("Expected retry after 1m, but got %s", r.RetryAfter)
+	}
+	if r.Reset != 3*time.Minute {
+		t.Fatalf("Expected reset after 3m, but got %s", r.Reset)
+	}
+
+	if r, _ := s.Take(ctx, "other", testPolicy, 1); !r.Allowed {
+		t.Fatal("Expected keys to have separate buckets")
+	}
+
+	now = now.Add(time.Minute)
+	if r, _ := s.Take(ctx, "k", testPolicy, 1); !r.Allowed {
+		t.Fatal("Expected one token to be refilled after a minute")
+	}
+
+	now = now.Add(time.Hour)
+	if r, _ := s.Peek(ctx, "k", testPolicy); r.Remaining != 3 {
+		t.Fatalf("Expected bucket capped at capacity, but got %d remaining", r.Remaining)
+	}
+	if r, _ := s.Take(ctx, "k", testPolicy, 4); r.Allowed {
+		t.Fatal("Expected cost above capacity to be denied")
+	}
+}
+
+func TestLimiter_Check(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), testPolicy)
+	e := echo.New()
+
+	check := func() (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.Set("client", "hash")
+		return rec, l.Check(c, "test", 2)
+	}
+
+	rec, err := check()
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	if rec.Header().Get("RateLimit-Limit") != "3" || rec.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("Unexpected headers: %v", rec.Header())
+	}
+
+	rec, err = check()
+	var coreErr *core.Error
+	if !errors.As(err, &coreErr) || coreErr.Code != 9000 || coreErr.Status != http.StatusTooManyRequests {
+		t.Fatalf("Expected core error 9000, but got '%v'", err)
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Expected Retry-After 60, but got '%s'", rec.Header().Get("Retry-After"))
+	}
+
+	if _, err := l.Allow(context.Background(), "missing", "hash", 1); err == nil {
+		t.Fatal("Expected error for unknown policy, but got nothing")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"dataShare/account"
+	"dataShare/config"
+	"dataShare/document"
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// uploadRequestPage is the upload form shown to the person an upload request was sent to.
+func uploadRequestPage(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	r, err := h.Request(c.Param("id"))
+	if err != nil {
+		return c.Render(http.StatusNotFound, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+
+	cfg := c.Get("config").(*config.Config)
+	return c.Render(http.StatusOK, "upload_request.html", map[string]interface{}{
+		"csrf":    c.Get("csrf"),
+		"request": r,
+		"maxSize": r.MaxSize >> 20,
+		"pow":     cfg.PoW.Enabled,
+	})
+}
+
+func uploadToRequest(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	if err := h.EncryptForRequest(c.Param("id"), form); err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	return c.Render(http.StatusOK, "upload_request.html", map[string]interface{}{
+		"sent": true,
+	})
+}
+
+// createUploadRequest opens an upload request and shows its secret, only this once: it is never put in a
+// link so that it can't end up in access logs, browser history or Referer headers.
+func createUploadRequest(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	maxSize, _ := strconv.ParseInt(c.FormValue("max_size"), 10, 64)
+	ttl, _ := time.ParseDuration(c.FormValue("expires_in"))
+	r, secret, err := h.CreateRequest(account.CurrentUser(c), c.FormValue("title"), maxSize<<20, ttl, c.FormValue("one_time") == "true")
+	if err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	return renderRequest(c, r, map[string]interface{}{
+		"secret": secret,
+	})
+}
+
+// uploadRequestDetails shows the requester the state of the request and asks for its secret.
+func uploadRequestDetails(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	r, err := h.OwnedRequest(account.CurrentUser(c), c.Param("id"))
+	if err != nil {
+		return c.Render(http.StatusNotFound, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	return renderRequest(c, r, map[string]interface{}{})
+}
+
+// uploadRequestUploads shows the requester the documents uploaded to the request and their keys,
+// unsealed with the posted secret.
+func uploadRequestUploads(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	r, uploads, err := h.RequestUploads(account.CurrentUser(c), c.Param("id"), c.FormValue("secret"))
+	if err != nil {
+		return c.Render(http.StatusNotFound, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+
+	statuses := make(map[string]string, len(uploads))
+	for _, u := range uploads {
+		statuses[u.Document.ID] = document.StatusName(u.Document.Status)
+	}
+	return renderRequest(c, r, map[string]interface{}{
+		"unlocked": true,
+		"uploads":  uploads,
+		"statuses": statuses,
+	})
+}
+
+// renderRequest renders the page of the upload request with data, which may hold its secret or the
+// keys of its uploads, so it is neither cached nor leaked in Referer headers.
+func renderRequest(c echo.Context, r *document.UploadRequest, data map[string]interface{}) error {
+	cfg := c.Get("config").(*config.Config)
+	data["csrf"] = c.Get("csrf")
+	data["request"] = r
+	data["status"] = document.RequestStatusName(r)
+	data["baseURL"] = cfg.App.BaseURL
+	data["uploadLink"] = cfg.App.BaseURL + "/upload/" + r.ID
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	return c.Render(http.StatusOK, "request.html", data)
+}
+
+func closeUploadRequest(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	if err := h.CloseRequest(account.CurrentUser(c), c.Param("id")); err != nil {
+		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
+	return c.Redirect(http.StatusSeeOther, "/account")
+}
+
+// registerRequestRoutes adds the upload request pages: the requester manages them from the account
+// pages, the uploader only needs the public /upload/:id link.
+func registerRequestRoutes(e *echo.Echo, requireUser echo.MiddlewareFunc, pageMiddlewares, uploadMiddlewares []echo.MiddlewareFunc) {
+	e.GET("/upload/:id", uploadRequestPage, pageMiddlewares...)
+	e.POST("/upload/:id", uploadToRequest, uploadMiddlewares...)
+
+	g := e.Group("/account/requests", requireUser)
+	g.POST("", createUploadRequest)
+	g.GET("/:id", uploadRequestDetails)
+	g.POST("/:id", uploadRequestUploads)
+	g.POST("/:id/close", closeUploadRequest)
+}
@@ -0,0 +1,182 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxChunkSize is the largest chunk sent to clamd at once. Larger writes are split.
+const maxChunkSize = 64 << 10
+
+var ErrScan = errors.New("clamd can't scan the stream")
+
+// Result is the verdict of clamd on a stream.
+type Result struct {
+	Infected bool
+	// Signature is the name of the malware found in an infected stream.
+	Signature string
+}
+
+// String returns "OK" for clean streams and the signature of infected ones.
+func (r Result) String() string {
+	if r.Infected {
+		return r.Signature
+	}
+	return "OK"
+}
+
+// Client sends streams to a clamd daemon listening on network ("tcp" or "unix") at address.
+type Client struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func NewClient(network, address string, timeout time.Duration) *Client {
+	return &Client{network: network, address: address, timeout: timeout}
+}
+
+// ParseAddress splits a clamd address, "tcp://host:port" or "unix:///path/to/clamd.sock", into its
+// network and address.
+func ParseAddress(s string) (string, string, error) {
+	network, address, found := strings.Cut(s, "://")
+	if !found || address == "" || (network != "tcp" && network != "unix") {
+		return "", "", fmt.Errorf("clamd address must be tcp://host:port or unix:///path, got %q", s)
+	}
+	return network, address, nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return nil, err
+	}
+	if c.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	return conn, nil
+}
+
+// Ping checks that clamd answers, it is used by the readiness check.
+func (c *Client) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("clamd unreachable: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("clamd unreachable: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return fmt.Errorf("clamd unreachable: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("clamd answered %q to PING", reply)
+	}
+	return nil
+}
+
+// Stream starts an INSTREAM scan. The content written to the stream is scanned once Result is called.
+func (c *Client) Stream(ctx context.Context) (*Stream, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Stream{conn: conn}, nil
+}
+
+// Stream sends the content written to it to clamd, in chunks prefixed with their length.
+type Stream struct {
+	conn net.Conn
+	err  error
+}
+
+func (s *Stream) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxChunkSize)]
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(chunk)))
+		if _, err := s.conn.Write(size[:]); err != nil {
+			s.err = s.failed(err)
+			return written, s.err
+		}
+		n, err := s.conn.Write(chunk)
+		written += n
+		if err != nil {
+			s.err = s.failed(err)
+			return written, s.err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// failed returns the reason clamd gave for closing the stream, such as its size limit, or err.
+func (s *Stream) failed(err error) error {
+	s.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if reply, readErr := readReply(s.conn); readErr == nil && reply != "" {
+		return fmt.Errorf("%w: %s", ErrScan, reply)
+	}
+	return err
+}
+
+// Err returns the error that stopped the stream, if any.
+func (s *Stream) Err() error {
+	return s.err
+}
+
+// Result ends the stream and returns the verdict of clamd.
+func (s *Stream) Result() (Result, error) {
+	defer s.conn.Close()
+	if s.err != nil {
+		return Result{}, s.err
+	}
+	if _, err := s.conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, s.failed(err)
+	}
+	reply, err := readReply(s.conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// Close abandons the stream without waiting for a verdict.
+func (s *Stream) Close() error {
+	return s.conn.Close()
+}
+
+// readReply reads a reply terminated by a null byte, as asked for by the "z" prefix of the commands.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && len(reply) == 0 {
+		return "", err
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply reads the verdict of an INSTREAM reply: "stream: OK", "stream: NAME FOUND" or "... ERROR".
+func parseReply(reply string) (Result, error) {
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	}
+	return Result{}, fmt.Errorf("%w: %s", ErrScan, reply)
+}
@@ -0,0 +1,91 @@
+package scan_test
+
+import (
+	"bytes"
+	"context"
+	"dataShare/scan"
+	"dataShare/scan/scantest"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		network string
+		valid   bool
+	}{
+		{"tcp://127.0.0.1:3310", "tcp", true},
+		{"unix:///run/clamav/clamd.ctl", "unix", true},
+		{"127.0.0.1:3310", "", false},
+		{"udp://127.0.0.1:3310", "", false},
+		{"tcp://", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			network, _, err := scan.ParseAddress(tt.address)
+			if (err == nil) != tt.valid || network != tt.network {
+				t.Fatalf("Expected network %q and valid %v, but got %q and %v", tt.network, tt.valid, network, err)
+			}
+		})
+	}
+}
+
+func TestClient_Stream(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		infected bool
+		err      bool
+	}{
+		{"Clean", "hello", false, false},
+		{"Empty", "", false, false},
+		{"Infected", "prefix " + scantest.EICAR + " suffix", true, false},
+		{"Chunked", strings.Repeat("a", 200<<10) + scantest.EICAR, true, false},
+		{"TooLarge", strings.Repeat("a", 2<<20), false, true},
+	}
+	servers := map[string]string{"tcp": "127.0.0.1:0", "unix": filepath.Join(t.TempDir(), "clamd.sock")}
+	for network, address := range servers {
+		server, err := scantest.NewServer(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		client := server.Client()
+		if err := client.Ping(context.Background()); err != nil {
+			t.Fatalf("Expected PONG, but got %v", err)
+		}
+		for _, tt := range tests {
+			t.Run(network+"/"+tt.name, func(t *testing.T) {
+				stream, err := client.Stream(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, err = stream.Write([]byte(tt.content))
+				var result scan.Result
+				if err == nil {
+					result, err = stream.Result()
+				}
+				stream.Close()
+				if tt.err {
+					if !errors.Is(err, scan.ErrScan) {
+						t.Fatalf("Expected ErrScan, but got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Infected != tt.infected || (tt.infected && result.Signature != scantest.Signature) {
+					t.Fatalf("Expected infected %v, but got %+v", tt.infected, result)
+				}
+				scanned := server.Scanned()
+				if !bytes.Equal(scanned[len(scanned)-1], []byte(tt.content)) {
+					t.Fatalf("Expected clamd to receive the whole content")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package scan
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// ContextScanner makes the clamd client available to handlers under the "scanner" key.
+func ContextScanner(c *Client) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set("scanner", c)
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package scantest provides a fake clamd for tests. It finds the EICAR test string, answers PING and
+// enforces a stream size limit like clamd does.
+package scantest
+
+import (
+	"bufio"
+	"bytes"
+	"dataShare/scan"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// EICAR is the standard antivirus test file, reported as Signature.
+const EICAR = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+const Signature = "Eicar-Test-Signature"
+
+// Server is a fake clamd listening on a TCP or Unix socket.
+type Server struct {
+	// MaxStreamSize is the size above which streams are rejected, like StreamMaxLength of clamd.
+	MaxStreamSize int
+
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	scanned  [][]byte
+}
+
+// NewServer starts a fake clamd on network ("tcp" or "unix") at address, "127.0.0.1:0" picks a free port.
+func NewServer(network, address string) (*Server, error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{MaxStreamSize: 1 << 20, listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Client returns a client of the server.
+func (s *Server) Client() *scan.Client {
+	return scan.NewClient(s.listener.Addr().Network(), s.listener.Addr().String(), 5*time.Second)
+}
+
+// Scanned returns the streams received so far.
+func (s *Server) Scanned() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.scanned
+}
+
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+	switch command {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var stream []byte
+		for {
+			var size [4]byte
+			if _, err := io.ReadFull(r, size[:]); err != nil {
+				return
+			}
+			n := int(binary.BigEndian.Uint32(size[:]))
+			if n == 0 {
+				break
+			}
+			if len(stream)+n > s.MaxStreamSize {
+				conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				// Reading the rest lets the client get the reply instead of a reset connection.
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				io.Copy(io.Discard, r)
+				return
+			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return
+			}
+			stream = append(stream, chunk...)
+		}
+		s.mu.Lock()
+		s.scanned = append(s.scanned, stream)
+		s.mu.Unlock()
+		if bytes.Contains(stream, []byte(EICAR)) {
+			conn.Write([]byte("stream: " + Signature + " FOUND\x00"))
+			return
+		}
+		conn.Write([]byte("stream: OK\x00"))
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
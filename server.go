@@ -1,23 +1,42 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"dataShare/account"
+	"dataShare/bruteforce"
+	"dataShare/clientip"
+	"dataShare/config"
 	"dataShare/core"
 	"dataShare/db"
 	"dataShare/document"
+	"dataShare/health"
+	"dataShare/pow"
+	"dataShare/ratelimit"
+	"dataShare/scan"
 	"dataShare/service"
+	"dataShare/sso"
+	"dataShare/tlsreload"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"gorm.io/gorm"
 	"html/template"
 	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -50,8 +69,15 @@ func NewDocumentHandler(c echo.Context) (*document.Handler, error) {
 }
 
 func indexHandler(c echo.Context) error {
+	cfg := c.Get("config").(*config.Config)
 	return c.Render(http.StatusOK, "home.html", map[string]interface{}{
-		"csrf": c.Get("csrf"),
+		"csrf":           c.Get("csrf"),
+		"pow":            cfg.PoW.Enabled,
+		"accounts":       cfg.Accounts.Enabled,
+		"anonymous":      cfg.Accounts.AllowAnonymousUploads,
+		"user":           account.CurrentUser(c),
+		"archiveFormat":  cfg.Archive.Format,
+		"archiveFormats": service.ArchiveFormats,
 	})
 }
 
@@ -76,9 +102,13 @@ func uploadDocument(c echo.Context) error {
 		})
 	}
 
+	cfg := c.Get("config").(*config.Config)
 	return c.Render(http.StatusOK, "upload_response.html", map[string]interface{}{
-		"link": os.Getenv("BASE_URL") + "/" + idKey.ID,
-		"key":  idKey.Key,
+		"link":       cfg.App.BaseURL + "/" + idKey.ID,
+		"key":        idKey.Key,
+		"shares":     idKey.Shares,
+		"manageLink": manageLink(cfg, idKey),
+		"sha256":     idKey.SHA256,
 	})
 }
 
@@ -90,16 +120,23 @@ func checkDocument(c echo.Context) error {
 		})
 	}
 	ID := c.Param("id")
-	err = h.Check(ID)
+	d, err := h.Check(ID)
 	if err != nil {
 		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
 			"errorMsg": err.Error(),
 		})
 	}
 
+	cfg := c.Get("config").(*config.Config)
 	return c.Render(http.StatusOK, "get_document.html", map[string]interface{}{
-		"csrf": c.Get("csrf"),
-		"id":   ID,
+		"csrf":       c.Get("csrf"),
+		"id":         ID,
+		"pow":        cfg.PoW.Enabled,
+		"recipients": d.Format == document.FormatAge,
+		"exportable": d.Format == document.FormatAgePassphrase,
+		"shares":     make([]struct{}, d.Threshold),
+		"passphrase": d.HasPassphrase,
+		"words":      d.KeyFormat == document.KeyWords,
 	})
 }
 
@@ -112,16 +149,276 @@ func downloadDocument(c echo.Context) error {
 	}
 	ID := c.Param("id")
 	key := c.FormValue("key")
-	content, d, err := h.Decrypt(core.NewIDKey(ID, key))
+	export := c.FormValue("export") == "true"
+	// The SHA-256 of the document, and the files of its archive, are shown first, to be downloaded one
+	// by one or all together.
+	if !export && c.FormValue("file") == "" {
+		listing, d, err := h.List(keyFromForm(c, ID, key))
+		if err != nil {
+			return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
+				"errorMsg": err.Error(),
+			})
+		}
+		if listing != nil {
+			return renderListing(c, d, listing)
+		}
+	}
+	dl, err := fetchDownload(c, h, ID, export)
 	if err != nil {
 		return c.Render(http.StatusUnprocessableEntity, "error.html", map[string]interface{}{
 			"errorMsg": err.Error(),
 		})
 	}
+	defer dl.content.Close()
+
+	if dl.d.ShowsText(export) {
+		text, err := dl.content.Bytes()
+		if err != nil {
+			return err
+		}
+		c.Response().Header().Set("Cache-Control", "no-store")
+		if err := c.Render(http.StatusOK, "secret.html", map[string]interface{}{
+			"text":   string(text),
+			"sha256": dl.content.SHA256,
+		}); err != nil {
+			return err
+		}
+		return h.Delivered(dl.d.ID, document.AllFiles, 0, int64(len(text)), int64(len(text)))
+	}
+
+	return sendDocument(c, h, dl)
+}
+
+// renderListing shows the SHA-256 of the document and the files of its archive. Each download form sends
+// the key again, the server doesn't keep it, so the page must not be cached.
+func renderListing(c echo.Context, d *document.Document, listing *document.Listing) error {
+	params, _ := c.FormParams()
+	cfg := c.Get("config").(*config.Config)
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	return c.Render(http.StatusOK, "download.html", map[string]interface{}{
+		"csrf":       c.Get("csrf"),
+		"id":         d.ID,
+		"pow":        cfg.PoW.Enabled,
+		"key":        c.FormValue("key"),
+		"passphrase": c.FormValue("passphrase"),
+		"shares":     params["shares"],
+		"files":      listing.Files,
+		"sha256":     listing.SHA256,
+		"filename":   d.Filename,
+		"size":       d.FileSize,
+	})
+}
+
+// download is the content asked for by a download request: the whole document or one file of its archive.
+type download struct {
+	d           *document.Document
+	file        int
+	filename    string
+	contentType string
+	content     *document.Content
+}
+
+// fetchDownload decrypts the file of the archive at the index of the "file" form field, or opens the
+// whole document when the field is empty or "all".
+func fetchDownload(c echo.Context, h *document.Handler, ID string, export bool) (*download, error) {
+	if export {
+		content, d, err := h.Export(ID)
+		if err != nil {
+			return nil, err
+		}
+		filename, contentType := d.Attachment(true)
+		return &download{d, document.AllFiles, filename, contentType, document.BytesContent(content)}, nil
+	}
+
+	ip := keyFromForm(c, ID, c.FormValue("key"))
+	file := c.FormValue("file")
+	if file == "" || file == "all" {
+		content, d, err := h.Open(ip)
+		if err != nil {
+			return nil, err
+		}
+		filename, contentType := d.Attachment(false)
+		return &download{d, document.AllFiles, filename, contentType, content}, nil
+	}
+	index, err := strconv.Atoi(file)
+	if err != nil {
+		return nil, core.NewError(http.StatusBadRequest, 2170, "Can't find file in the document")
+	}
+	name, content, d, err := h.DecryptFile(ip, index)
+	if err != nil {
+		return nil, err
+	}
+	// The type is sniffed like at upload, the name of the file is chosen by the sender.
+	head, err := content.From(0)
+	if err != nil {
+		content.Close()
+		return nil, core.NewError(http.StatusUnprocessableEntity, 2050, "Can't read file")
+	}
+	contentType, err := service.SniffReader(head)
+	if err != nil {
+		content.Close()
+		return nil, core.NewError(http.StatusUnprocessableEntity, 2050, "Can't read file")
+	}
+	return &download{d, index, name, contentType, content}, nil
+}
+
+// sendDocument sends the downloaded content, or the single byte range asked for with a Range header,
+// and records the bytes actually delivered so that an interrupted download can be resumed.
+func sendDocument(c echo.Context, h *document.Handler, dl *download) error {
+	size := dl.content.Size
+	header := c.Response().Header()
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": dl.filename}))
+	header.Set("Accept-Length", fmt.Sprintf("%d", size))
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("Content-Type", dl.contentType)
+	// The digests are those of the whole content, also sent with its byte ranges.
+	if sum, err := hex.DecodeString(dl.content.SHA256); err == nil && len(sum) > 0 {
+		digest := base64.StdEncoding.EncodeToString(sum)
+		header.Set("Repr-Digest", "sha-256=:"+digest+":")
+		header.Set("Digest", "sha-256="+digest)
+	}
+	// Only the recipients of the document can check its SHA-256, the server can't decrypt it.
+	if len(dl.content.SealedSHA256) > 0 {
+		header.Set("X-Sealed-Digest", base64.StdEncoding.EncodeToString(dl.content.SealedSHA256))
+	}
+
+	start, end, status := int64(0), size, http.StatusOK
+	if rangeHeader := c.Request().Header.Get("Range"); rangeHeader != "" {
+		var ok bool
+		start, end, ok = document.ParseRange(rangeHeader, size)
+		if !ok {
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return c.NoContent(http.StatusRequestedRangeNotSatisfiable)
+		}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, size))
+		status = http.StatusPartialContent
+	}
+	// Only the chunks from the one holding start are decrypted, not the bytes before it.
+	r, err := dl.content.From(start)
+	if err != nil {
+		return err
+	}
+	header.Set("Content-Length", strconv.FormatInt(end-start, 10))
+	c.Response().WriteHeader(status)
+	n, err := io.Copy(c.Response(), io.LimitReader(r, end-start))
+	if n > 0 || err == nil {
+		if err := h.Delivered(dl.d.ID, dl.file, start, start+n, size); err != nil {
+			log.Printf("Can't record delivery of document %s: %s", dl.d.ID, err)
+		}
+	}
+	return err
+}
+
+// keyFromForm returns the key of the download form, or the key shares entered instead when the key is split,
+// with the passphrase of the sender.
+func keyFromForm(c echo.Context, ID, key string) *core.IDKey {
+	ip := core.NewIDKey(ID, key)
+	ip.Passphrase = c.FormValue("passphrase")
+	params, _ := c.FormParams()
+	for _, share := range params["shares"] {
+		if share = strings.TrimSpace(share); share != "" {
+			ip.Shares = append(ip.Shares, share)
+		}
+	}
+	return ip
+}
+
+// manageLink returns the link to the sender's view of a document. The manage token is in the fragment,
+// which browsers never send, so that it stays out of access logs and Referer headers.
+func manageLink(cfg *config.Config, idKey *core.IDKey) string {
+	return cfg.App.BaseURL + "/manage/" + idKey.ID + "#" + url.PathEscape(idKey.ManageToken)
+}
+
+// manageForm asks for the manage token, filled in from the fragment of the manage link.
+func manageForm(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Render(http.StatusOK, "manage.html", map[string]interface{}{
+		"id":   c.Param("id"),
+		"csrf": c.Get("csrf"),
+	})
+}
+
+// manageDocument is the sender's view of the detailed state of a document. The manage token is posted.
+func manageDocument(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]interface{}{
+			"errorMsg": "Something went wrong",
+		})
+	}
+	d, err := h.Manage(c.Param("id"), c.FormValue("token"))
+	if err != nil {
+		return c.Render(http.StatusNotFound, "error.html", map[string]interface{}{
+			"errorMsg": err.Error(),
+		})
+	}
 
-	c.Response().Header().Set("Content-Disposition", "attachment; filename="+d.Filename)
-	c.Response().Header().Set("Accept-Length", fmt.Sprintf("%d", d.FileSize))
-	return c.Blob(http.StatusOK, d.FileContentType, content)
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.Render(http.StatusOK, "manage.html", map[string]interface{}{
+		"document":  d,
+		"status":    document.ShareStatus(d),
+		"expiresAt": d.UploadedAt.Add(document.ExpiresAfter),
+	})
+}
+
+// adminDocument returns the detailed state of any document.
+func adminDocument(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return err
+	}
+	d, err := h.Find(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"document":    d,
+		"status_name": document.StatusName(d.Status),
+	})
+}
+
+// uniformTiming makes every response of the route take at least minDuration, so that lookups of unknown,
+// expired or downloaded documents can't be told apart by their duration.
+func uniformTiming(minDuration time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			deadline := time.Now().Add(minDuration)
+			err := next(c)
+			time.Sleep(time.Until(deadline))
+			return err
+		}
+	}
+}
+
+// exemptResumes runs the middlewares, except for the downloads resumed in the resume window of a document
+// with the key that opened it: the byte ranges of a resumed download and the files of an archive are
+// requested one by one, and would otherwise each spend a download and need a new proof of work.
+func exemptResumes(middlewares ...echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		limited := next
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			limited = middlewares[i](limited)
+		}
+		return func(c echo.Context) error {
+			if c.FormValue("export") != "true" {
+				h, err := NewDocumentHandler(c)
+				if err == nil && h.Resuming(keyFromForm(c, c.Param("id"), c.FormValue("key"))) {
+					return next(c)
+				}
+			}
+			return limited(c)
+		}
+	}
+}
+
+func ContextConfig(cfg *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("config", cfg)
+			return next(c)
+		}
+	}
 }
 
 func ContextEncryption(e *service.Encryption) echo.MiddlewareFunc {
@@ -133,8 +430,25 @@ func ContextEncryption(e *service.Encryption) echo.MiddlewareFunc {
 	}
 }
 
-func initCleaningTask(stopChan chan os.Signal, db *gorm.DB) {
+func healthzHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, health.Report{Status: health.StatusOK})
+}
+
+// readyzHandler reports whether the instance can serve requests, explaining every failed dependency.
+func readyzHandler(checker *health.Checker) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		report := checker.Run(c.Request().Context())
+		if report.Status != health.StatusOK {
+			return c.JSON(http.StatusServiceUnavailable, report)
+		}
+		return c.JSON(http.StatusOK, report)
+	}
+}
+
+func initCleaningTask(stopChan chan os.Signal, cleanUp func(), hb *health.Heartbeat) {
 	ticker := time.NewTicker(1 * time.Minute)
+	cleanUp()
+	hb.Beat()
 	for {
 		select {
 		case <-stopChan:
@@ -143,28 +457,63 @@ func initCleaningTask(stopChan chan os.Signal, db *gorm.DB) {
 			return
 		case t := <-ticker.C:
 			fmt.Println("Cleaning task triggered at: ", t)
-			document.CleanUp(db)
+			cleanUp()
+			hb.Beat()
 		}
 	}
 }
 
+// configCheck implements the "config check" command: it prints the effective configuration with secrets redacted.
+func configCheck(args []string) int {
+	cfg, err := config.Load(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%s\n", err)
+		return 1
+	}
+	if err := cfg.Redacted().Write(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't print configuration: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
 // main is the entry point of the application.
-// It initializes the environment variables, establishes a database connection, performs database migration,
+// It loads the configuration, establishes a database connection, performs database migration,
 // creates a new Echo instance, defines a route to handle the root path, and starts the server.
 // It also uses a middleware for logging purposes.
 func main() {
-	err := godotenv.Load()
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "check" {
+		os.Exit(configCheck(args[2:]))
+	}
+
+	cfg, err := config.Load(args)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatalf("Invalid configuration:\n%s", err)
+	}
+	dbConn, err := getDatabaseConnection(cfg.Database)
+	if err != nil {
+		log.Fatalf("Failed to connect to database %s at %s:%d: %s", cfg.Database.Name, cfg.Database.Host, cfg.Database.Port, err)
 	}
-	appPort := os.Getenv("APP_PORT")
-	dbConn := getDatabaseConnection()
 	dbMigrate(dbConn)
 
 	// interrupt signal handling
-	stopChan := make(chan os.Signal)
+	stopChan := make(chan os.Signal, 1)
 	signal.Notify(stopChan, syscall.SIGINT, syscall.SIGTERM)
-	go initCleaningTask(stopChan, dbConn)
+	cleanupHeartbeat := &health.Heartbeat{}
+	store, limiter := getRateLimiter(cfg.RateLimit, dbConn)
+	detector := getBruteForceDetector(cfg.BruteForce)
+	issuer := getPoWIssuer(cfg.PoW)
+	go initCleaningTask(stopChan, func() {
+		document.CleanUp(dbConn, cfg.Downloads.ResumeWindow)
+		detector.Prune()
+		issuer.Prune()
+		if pg, ok := store.(*ratelimit.PostgresStore); ok {
+			if err := pg.Prune(maxRateLimitPeriod(cfg.RateLimit)); err != nil {
+				log.Printf("Can't prune rate limit buckets: %s", err)
+			}
+		}
+	}, cleanupHeartbeat)
 	go func() {
 		<-stopChan
 		// Print a message and exit the application
@@ -174,78 +523,355 @@ func main() {
 
 	e := echo.New()
 
-	e.IPExtractor = echo.ExtractIPDirect()
+	extractor, err := clientip.NewExtractor(cfg.ClientIP.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encryption := getEncryption(cfg.Encryption)
+	e.IPExtractor = extractor.ExtractIP
 	e.Use(db.ContextDB(dbConn))
-	e.Use(ContextEncryption(getEncryption()))
+	e.Use(ContextConfig(cfg))
+	e.Use(ContextEncryption(encryption))
+	e.Use(clientip.ContextClient(encryption.HashString, cfg.ClientIP.IPv4PrefixLength, cfg.ClientIP.IPv6PrefixLength))
+	e.Use(ratelimit.ContextLimiter(limiter))
+	e.Use(bruteforce.ContextDetector(detector))
+	var scanner *scan.Client
+	if cfg.Scan.Enabled() {
+		network, address, err := scan.ParseAddress(cfg.Scan.ClamdAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scanner = scan.NewClient(network, address, cfg.Scan.Timeout)
+		e.Use(scan.ContextScanner(scanner))
+	}
+	var sessions *account.Sessions
+	var provider *sso.Provider
+	if cfg.Accounts.Enabled {
+		sessions = getSessions(cfg)
+		e.Use(account.Authenticate(dbConn, encryption, sessions))
+	}
+	if cfg.OIDC.Enabled {
+		provider = getOIDCProvider(cfg)
+	}
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	if cfg.TLS.Enabled() && cfg.TLS.HSTSMaxAge > 0 {
+		e.Use(middleware.SecureWithConfig(middleware.SecureConfig{
+			HSTSMaxAge:            int(cfg.TLS.HSTSMaxAge.Seconds()),
+			HSTSExcludeSubdomains: !cfg.TLS.HSTSIncludeSubdomains,
+		}))
+	}
 	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
-		TokenLookup: "form:_csrf",
+		// Admin and API endpoints are authenticated with bearer tokens, not cookies.
+		Skipper: func(c echo.Context) bool {
+			return strings.HasPrefix(c.Path(), "/admin/") || strings.HasPrefix(c.Path(), "/api/")
+		},
+		TokenLookup:  "form:_csrf",
+		CookieSecure: cfg.TLS.Enabled(),
 	}))
 
 	templates := make(map[string]*template.Template)
 	templates["home.html"] = template.Must(template.ParseFiles("view/home.html", "view/base.html"))
 	templates["upload_response.html"] = template.Must(template.ParseFiles("view/upload_response.html", "view/base.html"))
 	templates["get_document.html"] = template.Must(template.ParseFiles("view/get_document.html", "view/base.html"))
+	templates["manage.html"] = template.Must(template.ParseFiles("view/manage.html", "view/base.html"))
+	templates["login.html"] = template.Must(template.ParseFiles("view/login.html", "view/base.html"))
+	templates["account.html"] = template.Must(template.ParseFiles("view/account.html", "view/base.html"))
+	templates["upload_request.html"] = template.Must(template.ParseFiles("view/upload_request.html", "view/base.html"))
+	templates["request.html"] = template.Must(template.ParseFiles("view/request.html", "view/base.html"))
+	templates["secret.html"] = template.Must(template.ParseFiles("view/secret.html", "view/base.html"))
+	templates["download.html"] = template.Must(template.ParseFiles("view/download.html", "view/base.html"))
 	templates["error.html"] = template.Must(template.ParseFiles("view/error.html", "view/base.html"))
+	e.HTTPErrorHandler = httpErrorHandler(e)
 	e.Renderer = &Template{
 		templates: templates,
 	}
 
 	e.Static("/static", "static")
 
-	e.GET("/", indexHandler)
-	e.POST("/", uploadDocument)
-	e.GET("/:id", checkDocument)
-	e.POST("/:id", downloadDocument)
+	checker := health.NewChecker(cfg.Health.Timeout)
+	checker.Add("database", health.DatabaseCheck(dbConn))
+	checker.Add("storage", health.StorageCheck(document.DataFolder, cfg.Health.MinFreeSpace))
+	checker.Add("cleanup", health.HeartbeatCheck(cleanupHeartbeat, cfg.Health.MaxCleanupAge))
+	if scanner != nil {
+		checker.Add("clamd", scanner.Ping)
+	}
+	e.GET("/healthz", healthzHandler)
+	e.GET("/readyz", readyzHandler(checker))
 
-	e.Logger.Fatal(e.Start("localhost:" + appPort))
-}
+	if cfg.Admin.Token != "" {
+		admin := e.Group("/admin", adminAuth(cfg.Admin.Token))
+		admin.GET("/bans", bruteforce.ListBans(detector))
+		admin.DELETE("/bans/:client", bruteforce.LiftBan(detector))
+		admin.GET("/documents/:id", adminDocument)
+	}
 
-// getDatabaseConnection returns the database connection object.
-func getDatabaseConnection() *gorm.DB {
-	dbHost := os.Getenv("DB_HOST")
-	dbName := os.Getenv("DB_NAME")
-	dbUser := os.Getenv("DB_USER")
-	dbPassword := os.Getenv("DB_PASSWORD")
-	dbPort, err := strconv.Atoi(os.Getenv("DB_PORT"))
-	if err != nil {
-		log.Fatalf("Failed to convert DB_PORT to int")
+	var uploadMiddlewares, checkMiddlewares, downloadMiddlewares []echo.MiddlewareFunc
+	// The proof of work and rate limit of downloads are only spent once per resume window.
+	var downloadLimits []echo.MiddlewareFunc
+	// Uploads to upload requests come from people without an account.
+	var indexMiddlewares, requestMiddlewares []echo.MiddlewareFunc
+	// Upload requests are created by signed in users.
+	requireUser := account.RequireUser("/login")
+	if cfg.OIDC.Enabled {
+		// Only senders signed in by the identity provider may upload, downloads stay public.
+		requireUser = account.RequireIssuer(cfg.OIDC.IssuerURL, "/login")
+		indexMiddlewares = append(indexMiddlewares, requireUser)
+		uploadMiddlewares = append(uploadMiddlewares, requireUser)
+	}
+	if cfg.Privacy.UniformResponses {
+		checkMiddlewares = append(checkMiddlewares, uniformTiming(cfg.Privacy.MinResponseTime))
+		downloadMiddlewares = append(downloadMiddlewares, uniformTiming(cfg.Privacy.MinResponseTime))
 	}
+	if cfg.PoW.Enabled {
+		difficulty := pow.LimiterDifficulty(limiter, map[string]string{
+			pow.ScopeUpload:   ratelimit.PolicyUpload,
+			pow.ScopeDownload: ratelimit.PolicyDownload,
+		}, cfg.PoW.Difficulty, cfg.PoW.MaxDifficulty)
+		e.GET("/pow/challenge", pow.ChallengeHandler(issuer, difficulty), ratelimit.Middleware(limiter, ratelimit.PolicyChallenge))
+		uploadMiddlewares = append(uploadMiddlewares, pow.Middleware(issuer, pow.ScopeUpload, difficulty))
+		requestMiddlewares = append(requestMiddlewares, pow.Middleware(issuer, pow.ScopeUpload, difficulty))
+		downloadLimits = append(downloadLimits, pow.Middleware(issuer, pow.ScopeDownload, difficulty))
+	}
+	if cfg.Accounts.Enabled {
+		uploadMiddlewares = append(uploadMiddlewares, account.OwnerAsClient(encryption.HashString))
+	}
+	uploadMiddlewares = append(uploadMiddlewares, ratelimit.Middleware(limiter, ratelimit.PolicyUpload))
+	requestMiddlewares = append(requestMiddlewares, ratelimit.Middleware(limiter, ratelimit.PolicyUpload))
+	checkMiddlewares = append(checkMiddlewares, ratelimit.Middleware(limiter, ratelimit.PolicyCheck))
+	downloadLimits = append(downloadLimits, ratelimit.Middleware(limiter, ratelimit.PolicyDownload))
+	downloadMiddlewares = append(downloadMiddlewares, exemptResumes(downloadLimits...))
 
-	dbConn, err := db.DatabaseConnection(dbHost, dbName, dbUser, dbPassword, dbPort)
-	if err != nil {
-		log.Fatalf("Failed to connect to database")
+	if cfg.Accounts.Enabled {
+		registerAccountRoutes(e, cfg, sessions, provider, ratelimit.Middleware(limiter, ratelimit.PolicyLogin))
+		registerRequestRoutes(e, requireUser, checkMiddlewares, requestMiddlewares)
 	}
-	return dbConn
+	registerAPIRoutes(e, cfg, uploadMiddlewares, checkMiddlewares, downloadMiddlewares)
+
+	e.GET("/", indexHandler, indexMiddlewares...)
+	e.POST("/", uploadDocument, uploadMiddlewares...)
+	e.GET("/manage/:id", manageForm)
+	e.POST("/manage/:id", manageDocument, ratelimit.Middleware(limiter, ratelimit.PolicyCheck))
+	e.GET("/:id", checkDocument, checkMiddlewares...)
+	e.POST("/:id", downloadDocument, downloadMiddlewares...)
+
+	e.Logger.Fatal(startServer(e, cfg, extractor))
 }
 
-func getEncryption() *service.Encryption {
-	iterations, err := strconv.Atoi(os.Getenv("ENCRYPTION_ITERATIONS"))
+// startServer serves plain HTTP, or HTTPS when TLS is configured.
+// With TLS the certificate is reloaded on SIGHUP or when its files change.
+func startServer(e *echo.Echo, cfg *config.Config, extractor *clientip.Extractor) error {
+	address := net.JoinHostPort(cfg.App.Host, strconv.Itoa(cfg.App.Port))
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		log.Fatalf("Failed to convert ENCRYPTION_ITERATIONS to int")
+		return err
+	}
+	if cfg.ClientIP.ProxyProtocol {
+		listener = clientip.NewProxyListener(listener, extractor, cfg.ClientIP.ProxyHeaderTimeout)
+	}
+	if !cfg.TLS.Enabled() {
+		e.Listener = listener
+		return e.Start(address)
 	}
 
-	bockSize, err := strconv.Atoi(os.Getenv("ENCRYPTION_BLOCK_SIZE_LENGTH"))
+	reloader, err := tlsreload.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
 	if err != nil {
-		log.Fatalf("Failed to convert ENCRYPTION_BLOCK_SIZE_LENGTH to int")
+		return err
 	}
+	go reloader.Watch(context.Background(), cfg.TLS.ReloadInterval)
 
-	saltLength, err := strconv.Atoi(os.Getenv("ENCRYPTION_SALT_LENGTH"))
-	if err != nil {
-		log.Fatalf("Failed to convert ENCRYPTION_SALT_LENGTH to int")
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			if err := reloader.Reload(); err != nil {
+				log.Printf("Certificate reload failed: %s", err)
+				continue
+			}
+			log.Printf("Certificate reloaded from %s", cfg.TLS.CertFile)
+		}
+	}()
+
+	if cfg.TLS.RedirectPort != 0 {
+		redirectAddress := net.JoinHostPort(cfg.App.Host, strconv.Itoa(cfg.TLS.RedirectPort))
+		go func() {
+			log.Fatal(http.ListenAndServe(redirectAddress, tlsreload.RedirectHandler(cfg.App.Port)))
+		}()
 	}
 
+	tlsConfig := reloader.TLSConfig()
+	e.TLSListener = tls.NewListener(listener, tlsConfig)
+	return e.StartServer(&http.Server{
+		Addr:      address,
+		TLSConfig: tlsConfig,
+	})
+}
+
+// getDatabaseConnection returns the database connection object.
+func getDatabaseConnection(cfg config.Database) (*gorm.DB, error) {
+	return db.DatabaseConnection(cfg.Host, cfg.Name, cfg.User, cfg.Password, cfg.Port)
+}
+
+func getEncryption(cfg config.Encryption) *service.Encryption {
 	return service.NewEncryption(
-		iterations,
-		bockSize,
-		saltLength,
-		os.Getenv("ENCRYPTION_HASH_SALT"),
+		cfg.Iterations,
+		cfg.BlockSize,
+		cfg.SaltLength,
+		cfg.HashSalt,
+	)
+}
+
+// getRateLimiter builds the limiter enforcing the per-route policies of cfg.
+func getRateLimiter(cfg config.RateLimit, dbConn *gorm.DB) (ratelimit.Store, *ratelimit.Limiter) {
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.Store == "postgres" {
+		store = ratelimit.NewPostgresStore(dbConn)
+	}
+	return store, ratelimit.NewLimiter(store,
+		ratelimit.Policy{
+			Name:     ratelimit.PolicyUpload,
+			Capacity: float64(cfg.Uploads),
+			Period:   cfg.UploadsPeriod,
+			ErrCode:  1040,
+			ErrMsg:   "Allowed number of uploads exceeded, try again later",
+		},
+		ratelimit.Policy{
+			Name:     ratelimit.PolicyUploadBytes,
+			Capacity: float64(cfg.UploadBytes),
+			Period:   cfg.UploadBytesPeriod,
+			ErrCode:  1050,
+			ErrMsg:   "Allowed upload size exceeded, try again later",
+		},
+		ratelimit.Policy{
+			Name:     ratelimit.PolicyDownload,
+			Capacity: float64(cfg.Downloads),
+			Period:   cfg.DownloadsPeriod,
+			ErrCode:  2090,
+			ErrMsg:   "Too many download attempts, try again later",
+		},
+		ratelimit.Policy{
+			Name:     ratelimit.PolicyLogin,
+			Capacity: float64(cfg.Logins),
+			Period:   cfg.LoginsPeriod,
+			ErrCode:  4120,
+			ErrMsg:   "Too many sign in attempts, try again later",
+		},
+		ratelimit.Policy{
+			Name:     ratelimit.PolicyCheck,
+			Capacity: float64(cfg.Checks),
+			Period:   cfg.ChecksPeriod,
+			ErrCode:  2100,
+			ErrMsg:   "Too many requests, try again later",
+		},
+		ratelimit.Policy{
+			Name:     ratelimit.PolicyChallenge,
+			Capacity: float64(cfg.Challenges),
+			Period:   cfg.ChallengesPeriod,
+			ErrCode:  3050,
+			ErrMsg:   "Too many proof of work challenges, try again later",
+		},
 	)
 }
 
+func getBruteForceDetector(cfg config.BruteForce) *bruteforce.Detector {
+	return bruteforce.NewDetector(bruteforce.Config{
+		Window:         cfg.Window,
+		DelayAfter:     cfg.DelayAfter,
+		BaseDelay:      cfg.BaseDelay,
+		MaxDelay:       cfg.MaxDelay,
+		BanAfter:       cfg.BanAfter,
+		BanDuration:    cfg.BanDuration,
+		MaxBanDuration: cfg.MaxBanDuration,
+	})
+}
+
+// getPoWIssuer returns the proof-of-work challenge issuer. Without a configured secret a random one
+// is used, which only works when a single instance serves the challenges and their solutions.
+func getPoWIssuer(cfg config.PoW) *pow.Issuer {
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Can't generate proof of work secret: %s", err)
+		}
+	}
+	return pow.NewIssuer(secret, cfg.TTL)
+}
+
+// sessionSecret returns the configured session secret. Without one a random secret is used,
+// which signs every user out on restart.
+func sessionSecret(cfg *config.Config) []byte {
+	secret := []byte(cfg.Accounts.SessionSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Can't generate session secret: %s", err)
+		}
+	}
+	return secret
+}
+
+// getSessions returns the session cookie manager.
+func getSessions(cfg *config.Config) *account.Sessions {
+	return account.NewSessions(sessionSecret(cfg), cfg.Accounts.SessionTTL, cfg.TLS.Enabled())
+}
+
+// getOIDCProvider discovers the OpenID Connect provider. The callback URL is derived from the base URL.
+func getOIDCProvider(cfg *config.Config) *sso.Provider {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	provider, err := sso.NewProvider(ctx, sso.Config{
+		IssuerURL:      cfg.OIDC.IssuerURL,
+		ClientID:       cfg.OIDC.ClientID,
+		ClientSecret:   cfg.OIDC.ClientSecret,
+		RedirectURL:    strings.TrimSuffix(cfg.App.BaseURL, "/") + "/auth/oidc/callback",
+		Scopes:         cfg.OIDC.Scopes,
+		AllowedDomains: cfg.OIDC.AllowedDomains,
+		AllowedGroups:  cfg.OIDC.AllowedGroups,
+		GroupsClaim:    cfg.OIDC.GroupsClaim,
+	}, sessionSecret(cfg), cfg.TLS.Enabled())
+	if err != nil {
+		log.Fatal(err)
+	}
+	return provider
+}
+
+// adminAuth only lets through requests with "Authorization: Bearer <token>".
+func adminAuth(token string) echo.MiddlewareFunc {
+	return middleware.KeyAuth(func(key string, c echo.Context) (bool, error) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1, nil
+	})
+}
+
+func maxRateLimitPeriod(cfg config.RateLimit) time.Duration {
+	return max(cfg.UploadsPeriod, cfg.UploadBytesPeriod, cfg.DownloadsPeriod, cfg.ChecksPeriod, cfg.LoginsPeriod)
+}
+
+// httpErrorHandler renders *core.Error returned by middlewares and handlers with the error page,
+// or as JSON for the API.
+func httpErrorHandler(e *echo.Echo) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		var coreErr *core.Error
+		if !errors.As(err, &coreErr) || c.Response().Committed {
+			e.DefaultHTTPErrorHandler(err, c)
+			return
+		}
+		if strings.HasPrefix(c.Path(), "/api/") {
+			if err := c.JSON(coreErr.Status, coreErr); err != nil {
+				e.Logger.Error(err)
+			}
+			return
+		}
+		if err := c.Render(coreErr.Status, "error.html", map[string]interface{}{
+			"errorMsg": coreErr.Error(),
+		}); err != nil {
+			e.Logger.Error(err)
+		}
+	}
+}
+
 func dbMigrate(db *gorm.DB) {
-	err := db.AutoMigrate(&document.Document{})
+	err := db.AutoMigrate(&document.Document{}, &ratelimit.RateLimitBucket{}, &account.User{}, &account.APIKey{}, &document.UploadRequest{}, &document.Upload{})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MaxRecipients is the maximum number of public keys a document can be encrypted to.
+const MaxRecipients = 20
+
+var ErrNoRecipients = errors.New("no recipients")
+
+// ParseRecipients parses age X25519 public keys, one per line. Empty lines and lines starting with # are ignored.
+func ParseRecipients(text string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := age.ParseX25519Recipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	if len(recipients) > MaxRecipients {
+		return nil, fmt.Errorf("at most %d recipients are allowed", MaxRecipients)
+	}
+	return recipients, nil
+}
+
+// EncryptToRecipients encrypts content in the age format, so that any of the recipients can decrypt it
+// with their private key and nobody else, the server included, can.
+func EncryptToRecipients(recipients []age.Recipient, content []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	w, err := EncryptToRecipientsStream(recipients, &buff)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// EncryptToRecipientsStream returns a writer encrypting to w like EncryptToRecipients. It must be closed
+// to write the end of the file.
+func EncryptToRecipientsStream(recipients []age.Recipient, w io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(w, recipients...)
+}
+
+// EncryptAge encrypts content in the age format with a scrypt passphrase, so that the stored file can
+// be decrypted offline with "age -d".
+func (e *Encryption) EncryptAge(passphrase string, content []byte) ([]byte, error) {
+	var buff bytes.Buffer
+	w, err := e.EncryptAgeStream(passphrase, &buff)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// EncryptAgeStream returns a writer encrypting to w like EncryptAge. It must be closed to write the end of the file.
+func (e *Encryption) EncryptAgeStream(passphrase string, w io.Writer) (io.WriteCloser, error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptToRecipientsStream([]age.Recipient{r}, w)
+}
+
+// DecryptAge decrypts content encrypted by EncryptAge.
+func (e *Encryption) DecryptAge(passphrase string, cipherContent []byte) ([]byte, error) {
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(cipherContent), identity)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"filippo.io/age"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestEncryptToRecipients(t *testing.T) {
+	alice, _ := age.GenerateX25519Identity()
+	bob, _ := age.GenerateX25519Identity()
+	eve, _ := age.GenerateX25519Identity()
+
+	recipients, err := ParseRecipients("# team\n" + alice.Recipient().String() + "\n\n  " + bob.Recipient().String() + "  \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 2 {
+		t.Fatalf("Expected 2 recipients, but got %d", len(recipients))
+	}
+	ciphertext, err := EncryptToRecipients(recipients, []byte("SomeContent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		identity age.Identity
+		err      bool
+	}{
+		{"FirstRecipient", alice, false},
+		{"SecondRecipient", bob, false},
+		{"NotARecipient", eve, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := age.Decrypt(bytes.NewReader(ciphertext), tt.identity)
+			if tt.err {
+				if err == nil {
+					t.Fatal("Expected an error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(r)
+			if err != nil || string(content) != "SomeContent" {
+				t.Fatalf("Expected SomeContent, but got %q (%v)", content, err)
+			}
+		})
+	}
+}
+
+func TestParseRecipients_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"Empty", "\n# nothing\n", ErrNoRecipients.Error()},
+		{"NotAKey", "age1nope", "line 1"},
+		{"TooMany", strings.Repeat(mustRecipient(t)+"\n", MaxRecipients+1), "at most 20 recipients"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRecipients(tt.input)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("Expected error containing '%s', but got '%v'", tt.err, err)
+			}
+			if tt.name == "Empty" && !errors.Is(err, ErrNoRecipients) {
+				t.Fatalf("Expected ErrNoRecipients, but got %v", err)
+			}
+		})
+	}
+}
+
+func mustRecipient(t *testing.T) string {
+	i, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i.Recipient().String()
+}
+
+func TestEncryptAge(t *testing.T) {
+	e := NewEncryption(1, 32, 16, "SomeHashSalt")
+	ciphertext, err := e.EncryptAge("SomeKey", []byte("SomeContent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(ciphertext, []byte("age-encryption.org/v1\n")) {
+		t.Fatalf("Expected an age file, but got %q", ciphertext[:20])
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+		err      bool
+	}{
+		{"RightKey", "SomeKey", "SomeContent", false},
+		{"WrongKey", "OtherKey", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := e.DecryptAge(tt.key, ciphertext)
+			if (err != nil) != tt.err {
+				t.Fatalf("Expected error %v, but got %v", tt.err, err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Expected %q, but got %q", tt.expected, content)
+			}
+		})
+	}
+}
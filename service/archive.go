@@ -0,0 +1,218 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime/multipart"
+	"time"
+)
+
+// Formats of the archives multiple uploaded files are bundled in.
+const (
+	ArchiveZip    = "zip"
+	ArchiveTar    = "tar"
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+)
+
+// ArchiveFormats are the supported archive formats.
+var ArchiveFormats = []string{ArchiveZip, ArchiveTar, ArchiveTarGz, ArchiveTarZst}
+
+var ErrNoSuchFile = errors.New("no such file in the archive")
+
+var archiveContentTypes = map[string]string{
+	ArchiveZip:    "application/zip",
+	ArchiveTar:    "application/x-tar",
+	ArchiveTarGz:  "application/gzip",
+	ArchiveTarZst: "application/zstd",
+}
+
+// ValidArchiveFormat reports whether format is one of ArchiveFormats.
+func ValidArchiveFormat(format string) bool {
+	_, ok := archiveContentTypes[format]
+	return ok
+}
+
+// Archive is how multiple uploaded files are bundled.
+type Archive struct {
+	Format string
+	// Name is the file name of the archive, without the extension of the format.
+	Name string
+	// Level is the compression level, from 0 (none) to 9 (best), or DefaultCompression.
+	Level int
+}
+
+// FileName returns the name of the archive with the extension of its format.
+func (a Archive) FileName() string {
+	return a.Name + "." + a.Format
+}
+
+// ManifestEntry describes a file of an archive.
+type ManifestEntry struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// write streams the files into the archive written to w, one after the other, and returns their manifest.
+// Each file is checked by check.
+func (a Archive) write(w io.Writer, files []*multipart.FileHeader, names []string, check ContentCheck) ([]ManifestEntry, error) {
+	switch a.Format {
+	case ArchiveTar:
+		return writeTar(w, files, names, check)
+	case ArchiveTarGz:
+		level := a.Level
+		if level == DefaultCompression {
+			level = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := writeTar(gw, files, names, check)
+		if err != nil {
+			return nil, err
+		}
+		return manifest, gw.Close()
+	case ArchiveTarZst:
+		options := []zstd.EOption{}
+		if a.Level != DefaultCompression {
+			// zstd levels go up to 22, the 0 to 9 scale of flate is mapped to the first ones.
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(max(a.Level, 1))))
+		}
+		zw, err := zstd.NewWriter(w, options...)
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := writeTar(zw, files, names, check)
+		if err != nil {
+			zw.Close()
+			return nil, err
+		}
+		return manifest, zw.Close()
+	}
+	return writeZip(w, files, names, a.Level, check)
+}
+
+func writeZip(w io.Writer, files []*multipart.FileHeader, names []string, level int, check ContentCheck) ([]ManifestEntry, error) {
+	zipWriter := zip.NewWriter(w)
+	method := zip.Deflate
+	switch {
+	case level == flate.NoCompression:
+		method = zip.Store
+	case level != DefaultCompression:
+		zipWriter.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+
+	manifest := make([]ManifestEntry, len(files))
+	now := time.Now()
+	for i, file := range files {
+		fileWriter, err := zipWriter.CreateHeader(&zip.FileHeader{Name: names[i], Method: method, Modified: now})
+		if err != nil {
+			return nil, err
+		}
+		if manifest[i], err = copyEntry(fileWriter, file, names[i], check); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, zipWriter.Close()
+}
+
+// writeTar writes the files as regular files readable by everyone, uploads don't carry permissions.
+func writeTar(w io.Writer, files []*multipart.FileHeader, names []string, check ContentCheck) ([]ManifestEntry, error) {
+	tarWriter := tar.NewWriter(w)
+	manifest := make([]ManifestEntry, len(files))
+	now := time.Now().Truncate(time.Second)
+	for i, file := range files {
+		err := tarWriter.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     names[i],
+			Size:     file.Size,
+			Mode:     0644,
+			ModTime:  now,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if manifest[i], err = copyEntry(tarWriter, file, names[i], check); err != nil {
+			return nil, err
+		}
+	}
+	return manifest, tarWriter.Close()
+}
+
+// copyEntry copies the uploaded file to w and returns its manifest entry.
+func copyEntry(w io.Writer, file *multipart.FileHeader, name string, check ContentCheck) (ManifestEntry, error) {
+	hash := sha256.New()
+	if _, err := copyPart(io.MultiWriter(w, hash), file, name, check); err != nil {
+		return ManifestEntry{}, err
+	}
+	return ManifestEntry{Name: name, Size: file.Size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// OpenFile returns the name, the size and the content of the index-th file of an archive in the
+// given format, read from archive of size bytes. The file is only decompressed as it is read, tar
+// archives are read sequentially up to it.
+func OpenFile(format string, archive io.ReaderAt, size int64, index int) (string, int64, io.ReadCloser, error) {
+	if format == ArchiveZip {
+		r, err := zip.NewReader(archive, size)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		if index < 0 || index >= len(r.File) {
+			return "", 0, nil, ErrNoSuchFile
+		}
+		src, err := r.File[index].Open()
+		if err != nil {
+			return "", 0, nil, err
+		}
+		return r.File[index].Name, int64(r.File[index].UncompressedSize64), src, nil
+	}
+
+	var src io.Reader = io.NewSectionReader(archive, 0, size)
+	var closer io.Closer = io.NopCloser(nil)
+	switch format {
+	case ArchiveTarGz:
+		gr, err := gzip.NewReader(src)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		src, closer = gr, gr
+	case ArchiveTarZst:
+		zr, err := zstd.NewReader(src)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		src, closer = zr, zr.IOReadCloser()
+	}
+	tr := tar.NewReader(src)
+	for i := 0; ; {
+		header, err := tr.Next()
+		if err == io.EOF {
+			err = ErrNoSuchFile
+		}
+		if err != nil {
+			closer.Close()
+			return "", 0, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if i == index {
+			return header.Name, header.Size, struct {
+				io.Reader
+				io.Closer
+			}{tr, closer}, nil
+		}
+		i++
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"errors"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// MinKeyWords is the minimum length of word keys: 6 words of the EFF large wordlist give 77.5 bits
+// of entropy, more than the 74.5 bits of the 12 character keys of NewKey.
+const MinKeyWords = 6
+
+const wordSeparator = "-"
+
+// effLargeWordlist is the EFF large wordlist (https://www.eff.org/dice), licensed under CC BY 3.0 US.
+//
+//go:embed eff_large_wordlist.txt
+var effLargeWordlist string
+
+var wordlist = func() []string {
+	lines := strings.Split(strings.TrimSpace(effLargeWordlist), "\n")
+	words := make([]string, 0, len(lines))
+	for _, line := range lines {
+		_, word, _ := strings.Cut(line, "\t")
+		words = append(words, word)
+	}
+	return words
+}()
+
+// NewWordKey returns a key of n random words of the EFF large wordlist, like "mango-reptile-...".
+func NewWordKey(n int) (string, error) {
+	if n < MinKeyWords {
+		return "", errors.New("word keys need at least 6 words")
+	}
+	words := make([]string, n)
+	count := big.NewInt(int64(len(wordlist)))
+	for i := range words {
+		index, err := rand.Int(rand.Reader, count)
+		if err != nil {
+			return "", err
+		}
+		words[i] = wordlist[index.Int64()]
+	}
+	return strings.Join(words, wordSeparator), nil
+}
+
+// NormalizeWordKey returns the word key as generated by NewWordKey whatever its case and the separators
+// typed between the words, so that "Mango Reptile" and "mango.reptile" are the same key.
+func NormalizeWordKey(key string) string {
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	return strings.Join(words, wordSeparator)
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewWordKey(t *testing.T) {
+	if len(wordlist) != 7776 {
+		t.Fatalf("Expected 7776 words, but got %d", len(wordlist))
+	}
+	key, err := NewWordKey(MinKeyWords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if words := strings.Split(key, wordSeparator); len(words) < MinKeyWords {
+		t.Fatalf("Expected at least %d words, but got %q", MinKeyWords, key)
+	}
+	if NormalizeWordKey(key) != key {
+		t.Fatalf("Expected generated key %q to be normalized, but got %q", key, NormalizeWordKey(key))
+	}
+	if _, err := NewWordKey(MinKeyWords - 1); err == nil {
+		t.Fatal("Expected an error for too few words, but got none")
+	}
+}
+
+func TestNormalizeWordKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"Generated", "abacus-zoom-t-shirt", "abacus-zoom-t-shirt"},
+		{"Case", "Abacus-ZOOM-T-Shirt", "abacus-zoom-t-shirt"},
+		{"Spaces", "  abacus zoom  t shirt ", "abacus-zoom-t-shirt"},
+		{"Mixed", "abacus.zoom_t/shirt", "abacus-zoom-t-shirt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if normalized := NormalizeWordKey(tt.key); normalized != tt.expected {
+				t.Fatalf("Expected %q, but got %q", tt.expected, normalized)
+			}
+		})
+	}
+}
@@ -27,17 +27,29 @@ func NewEncryption(iterations, blockSize, saltLength int, hashSalt string) *Encr
 	}
 }
 
-func (e *Encryption) deriveKey(key string, salt []byte) ([]byte, []byte) {
+// deriveKey derives the AES key from the generated key and the optional passphrase chosen by the sender.
+// Without a passphrase the derivation is the same as before passphrases existed.
+func (e *Encryption) deriveKey(key, passphrase string, salt []byte) ([]byte, []byte) {
 	// http://www.ietf.org/rfc/rfc2898.txt
 	if salt == nil {
 		salt = make([]byte, e.saltLength)
 		rand.Read(salt)
 	}
-	return pbkdf2.Key([]byte(key), salt, e.iterations, e.blockSize, sha256.New), salt
+	secret := []byte(key)
+	if passphrase != "" {
+		// The NUL separator keeps characters from moving between the key and the passphrase.
+		secret = append(append(secret, 0), passphrase...)
+	}
+	return pbkdf2.Key(secret, salt, e.iterations, e.blockSize, sha256.New), salt
 }
 
 func (e *Encryption) Encrypt(key string, content []byte) ([]byte, error) {
-	derivedKey, salt := e.deriveKey(key, nil)
+	return e.EncryptWithPassphrase(key, "", content)
+}
+
+// EncryptWithPassphrase encrypts content so that both the key and the passphrase are needed to decrypt it.
+func (e *Encryption) EncryptWithPassphrase(key, passphrase string, content []byte) ([]byte, error) {
+	derivedKey, salt := e.deriveKey(key, passphrase, nil)
 	iv := make([]byte, e.ivLength)
 	// http://nvlpubs.nist.gov/nistpubs/Legacy/SP/nistspecialpublication800-38d.pdf
 	// Section 8.2
@@ -55,9 +67,14 @@ func (e *Encryption) Encrypt(key string, content []byte) ([]byte, error) {
 }
 
 func (e *Encryption) Decrypt(key string, cipherContent []byte) ([]byte, error) {
+	return e.DecryptWithPassphrase(key, "", cipherContent)
+}
+
+// DecryptWithPassphrase decrypts content encrypted by EncryptWithPassphrase.
+func (e *Encryption) DecryptWithPassphrase(key, passphrase string, cipherContent []byte) ([]byte, error) {
 	// The salt and iv are the first saltLength and ivLength bytes of the cipherContent
 	salt, iv, data := cipherContent[:e.saltLength], cipherContent[e.saltLength:e.saltLength+e.ivLength], cipherContent[e.saltLength+e.ivLength:]
-	derivedKey, _ := e.deriveKey(key, salt)
+	derivedKey, _ := e.deriveKey(key, passphrase, salt)
 	b, err := aes.NewCipher(derivedKey)
 	if err != nil {
 		return nil, err
@@ -64,3 +64,44 @@ func TestEncryption(t *testing.T) {
 		})
 	}
 }
+
+func TestEncryptWithPassphrase(t *testing.T) {
+	e := NewEncryption(1, 32, 16, "SomeHashSalt")
+	ciphertext, err := e.EncryptWithPassphrase("SomeKey", "SomePassphrase", []byte("SomeContent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		passphrase string
+		err        bool
+	}{
+		{"RightKeyAndPassphrase", "SomeKey", "SomePassphrase", false},
+		{"MissingPassphrase", "SomeKey", "", true},
+		{"WrongPassphrase", "SomeKey", "OtherPassphrase", true},
+		{"WrongKey", "OtherKey", "SomePassphrase", true},
+		{"Shifted", "SomeKeyS", "omePassphrase", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := e.DecryptWithPassphrase(tt.key, tt.passphrase, ciphertext)
+			if (err != nil) != tt.err {
+				t.Fatalf("Expected error %v, but got %v", tt.err, err)
+			}
+			if !tt.err && string(plaintext) != "SomeContent" {
+				t.Fatalf("Expected content 'SomeContent' but got '%s'", plaintext)
+			}
+		})
+	}
+
+	// Without a passphrase the format is unchanged.
+	ciphertext, err = e.Encrypt("SomeKey", []byte("SomeContent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.DecryptWithPassphrase("SomeKey", "", ciphertext); err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+}
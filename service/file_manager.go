@@ -1,106 +1,157 @@
 package service
 
 import (
-	"archive/zip"
-	"bytes"
+	"crypto/sha256"
 	"io"
 	"mime/multipart"
+	"os"
+	"path"
+	"strings"
 )
 
-const zipFileName = "archive.zip"
+// DefaultCompression lets the archive format choose its compression level.
+const DefaultCompression = -1
 
+// File is the content of an upload. It is only read when it is written to the encryption, so that large
+// uploads and archives are never held in memory.
 type File struct {
-	Name        string
-	Content     []byte
+	Name string
+	// Size is the size of the content. For archives it is only known once the file was written.
 	Size        int64
 	ContentType string
+	// Archive is the format of the archive bundling the uploaded files, "" for a single file.
+	Archive string
+	// Manifest lists the files of an archive, once it was written.
+	Manifest []ManifestEntry
+	// SHA256 is the SHA-256 of the content, once it was written.
+	SHA256 []byte
+	// Check is called with the type sniffed from the first bytes of each uploaded file, before they are
+	// written. ContentType is the sniffed type of single files once they were written.
+	Check ContentCheck
+	write func(w io.Writer) error
 }
 
-func SaveFile(w io.Writer, content []byte) error {
-	_, err := w.Write(content)
-	return err
+// WriteTo writes the content of the file to w.
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	hash := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(w, hash)}
+	err := f.write(cw)
+	f.Size = cw.n
+	f.SHA256 = hash.Sum(nil)
+	return cw.n, err
 }
 
-func GetFileFromFileHeader(files []*multipart.FileHeader) (*File, error) {
-	if len(files) == 1 {
-		return getFileFromSingleFileHeader(files[0])
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+const textFileName = "secret.txt"
+
+// NewTextFile returns a secret text as a file.
+func NewTextFile(text string) *File {
+	return &File{
+		Name:        textFileName,
+		Size:        int64(len(text)),
+		ContentType: "text/plain; charset=utf-8",
+		write: func(w io.Writer) error {
+			_, err := io.WriteString(w, text)
+			return err
+		},
 	}
-	return getFileFromMultipleFileHeader(files)
 }
 
-func getFileFromSingleFileHeader(file *multipart.FileHeader) (*File, error) {
-	src, err := file.Open()
-	if err != nil {
-		return nil, err
+// NewLocalFile returns the file at filePath as an upload named name.
+func NewLocalFile(filePath, name, contentType string, size int64) *File {
+	f := &File{
+		Name:        name,
+		Size:        size,
+		ContentType: contentType,
 	}
-	defer src.Close()
+	f.write = func(w io.Writer) error {
+		src, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		f.ContentType, err = copyChecked(w, src, name, contentType, f.Check)
+		return err
+	}
+	return f
+}
 
-	content, err := io.ReadAll(src)
-	if err != nil {
-		return nil, err
+// GetFileFromFileHeader returns the uploaded file, or an archive of the uploaded files. paths are the
+// relative paths of the files of a folder upload, kept in the archive.
+func GetFileFromFileHeader(files []*multipart.FileHeader, paths []string, archive Archive) *File {
+	if len(files) == 1 {
+		return getFileFromSingleFileHeader(files[0])
 	}
+	return getFileFromMultipleFileHeader(files, archiveNames(files, paths), archive)
+}
 
-	return &File{
+func getFileFromSingleFileHeader(file *multipart.FileHeader) *File {
+	f := &File{
 		Name:        file.Filename,
-		Content:     content,
 		Size:        file.Size,
 		ContentType: file.Header.Get("Content-Type"),
-	}, nil
+	}
+	f.write = func(w io.Writer) error {
+		var err error
+		f.ContentType, err = copyPart(w, file, file.Filename, f.Check)
+		return err
+	}
+	return f
 }
 
-func getFileFromMultipleFileHeader(files []*multipart.FileHeader) (*File, error) {
-	content, err := compressFiles(files)
-	if err != nil {
-		return nil, err
+func getFileFromMultipleFileHeader(files []*multipart.FileHeader, names []string, archive Archive) *File {
+	f := &File{
+		Name:        archive.FileName(),
+		ContentType: archiveContentTypes[archive.Format],
+		Archive:     archive.Format,
 	}
-
-	return &File{
-		Name:        zipFileName,
-		Content:     content,
-		Size:        int64(len(content)),
-		ContentType: "application/zip",
-	}, nil
-
+	f.write = func(w io.Writer) error {
+		var err error
+		f.Manifest, err = archive.write(w, files, names, f.Check)
+		return err
+	}
+	return f
 }
 
-// CompressFiles receives a list of files and returns a byte array representing the ZIP file.
-func compressFiles(files []*multipart.FileHeader) ([]byte, error) {
-	buff := new(bytes.Buffer)
-	zipWriter := zip.NewWriter(buff)
-
-	// Process each file.
-	for _, file := range files {
-		i, err2 := addToZip(zipWriter, file)
-		if err2 != nil {
-			return i, err2
+// archiveNames returns the names of the files in an archive: their relative paths when every file has
+// one, their file names otherwise.
+func archiveNames(files []*multipart.FileHeader, paths []string) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.Filename
+		if len(paths) != len(files) {
+			continue
+		}
+		if name := cleanArchivePath(paths[i]); name != "" {
+			names[i] = name
 		}
 	}
-
-	// All files have been written to the zip file; we can close it.
-	if err := zipWriter.Close(); err != nil {
-		return nil, err
-	}
-
-	// Return the buffer's contents (the complete zip file) as a byte array.
-	return buff.Bytes(), nil
+	return names
 }
 
-func addToZip(zipWriter *zip.Writer, file *multipart.FileHeader) ([]byte, error) {
-	// Add file to zip
-	fileWriter, err := zipWriter.Create(file.Filename)
-	if err != nil {
-		return nil, err
-	}
+// cleanArchivePath returns the relative path p without any part leaving the archive, or "" if nothing is left.
+func cleanArchivePath(p string) string {
+	p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+	return strings.TrimPrefix(p, "/")
+}
 
-	// Open the file, copy its content into the zip file.
+// copyPart copies the content of the uploaded file, named name, to w once check accepts its sniffed type,
+// and returns that type.
+func copyPart(w io.Writer, file *multipart.FileHeader, name string, check ContentCheck) (string, error) {
 	src, err := file.Open()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	defer src.Close()
-
-	if _, err := io.Copy(fileWriter, src); err != nil {
-		return nil, err
-	}
-	return nil, nil
+	return copyChecked(w, src, name, file.Header.Get("Content-Type"), check)
 }
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func uploadedFiles(t *testing.T, contents map[string]string, order []string) []*multipart.FileHeader {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, name := range order {
+		part, err := w.CreateFormFile("files", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(contents[name]))
+	}
+	w.Close()
+	form, err := multipart.NewReader(&body, w.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.File["files"]
+}
+
+func TestGetFileFromFileHeader_Zip(t *testing.T) {
+	contents := map[string]string{"a.txt": "first", "b.txt": "second", "c.txt": "third"}
+	files := uploadedFiles(t, contents, []string{"a.txt", "b.txt", "c.txt"})
+	tests := []struct {
+		name   string
+		paths  []string
+		level  int
+		names  []string
+		method uint16
+	}{
+		{"Names", nil, DefaultCompression, []string{"a.txt", "b.txt", "c.txt"}, zip.Deflate},
+		{"Paths", []string{"dir/a.txt", "dir/sub/b.txt", "c.txt"}, 9, []string{"dir/a.txt", "dir/sub/b.txt", "c.txt"}, zip.Deflate},
+		{"UnsafePaths", []string{"../../a.txt", "/etc/b.txt", ""}, 0, []string{"a.txt", "etc/b.txt", "c.txt"}, zip.Store},
+		{"MissingPaths", []string{"dir/a.txt"}, 0, []string{"a.txt", "b.txt", "c.txt"}, zip.Store},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := GetFileFromFileHeader(files, tt.paths, Archive{Format: ArchiveZip, Name: "archive", Level: tt.level})
+			if file.Name != "archive.zip" || file.ContentType != "application/zip" {
+				t.Fatalf("Expected archive.zip, but got %s (%s)", file.Name, file.ContentType)
+			}
+			var archive bytes.Buffer
+			if _, err := file.WriteTo(&archive); err != nil {
+				t.Fatal(err)
+			}
+			if file.Size != int64(archive.Len()) {
+				t.Fatalf("Expected size %d, but got %d", archive.Len(), file.Size)
+			}
+			if sum := sha256.Sum256(archive.Bytes()); !bytes.Equal(file.SHA256, sum[:]) {
+				t.Fatalf("Expected SHA-256 %x, but got %x", sum, file.SHA256)
+			}
+			r, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(r.File) != len(tt.names) {
+				t.Fatalf("Expected %d files, but got %d", len(tt.names), len(r.File))
+			}
+			for i, f := range r.File {
+				if f.Name != tt.names[i] || f.Method != tt.method {
+					t.Fatalf("Expected %s with method %d, but got %s with method %d", tt.names[i], tt.method, f.Name, f.Method)
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(rc)
+				rc.Close()
+				if expected := contents[files[i].Filename]; string(content) != expected {
+					t.Fatalf("Expected content %q, but got %q", expected, content)
+				}
+			}
+		})
+	}
+}
+
+func TestGetFileFromFileHeader_Tar(t *testing.T) {
+	contents := map[string]string{"a.txt": "first", "b.txt": strings.Repeat("second", 1000)}
+	files := uploadedFiles(t, contents, []string{"a.txt", "b.txt"})
+	paths := []string{"dir/a.txt", "dir/b.txt"}
+	tests := []struct {
+		format      string
+		level       int
+		contentType string
+		decompress  func(r io.Reader) (io.Reader, error)
+	}{
+		{ArchiveTar, DefaultCompression, "application/x-tar", func(r io.Reader) (io.Reader, error) { return r, nil }},
+		{ArchiveTarGz, DefaultCompression, "application/gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{ArchiveTarGz, 9, "application/gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{ArchiveTarZst, DefaultCompression, "application/zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{ArchiveTarZst, 0, "application/zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			file := GetFileFromFileHeader(files, paths, Archive{Format: tt.format, Name: "bundle", Level: tt.level})
+			if file.Name != "bundle."+tt.format || file.ContentType != tt.contentType {
+				t.Fatalf("Expected bundle.%s (%s), but got %s (%s)", tt.format, tt.contentType, file.Name, file.ContentType)
+			}
+			var archive bytes.Buffer
+			if _, err := file.WriteTo(&archive); err != nil {
+				t.Fatal(err)
+			}
+			r, err := tt.decompress(&archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(r)
+			for i, path := range paths {
+				header, err := tr.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(tr)
+				if header.Name != path || header.Mode != 0644 || string(content) != contents[files[i].Filename] {
+					t.Fatalf("Expected %s with mode 644 and its content, but got %s with mode %o", path, header.Name, header.Mode)
+				}
+			}
+			if _, err := tr.Next(); err != io.EOF {
+				t.Fatalf("Expected the end of the archive, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenFile(t *testing.T) {
+	contents := map[string]string{"a.txt": "first", "b.txt": "second", "c.txt": "third"}
+	order := []string{"a.txt", "b.txt", "c.txt"}
+	files := uploadedFiles(t, contents, order)
+	paths := []string{"dir/a.txt", "dir/sub/b.txt", "c.txt"}
+	for _, format := range ArchiveFormats {
+		t.Run(format, func(t *testing.T) {
+			file := GetFileFromFileHeader(files, paths, Archive{Format: format, Name: "archive", Level: DefaultCompression})
+			var archive bytes.Buffer
+			if _, err := file.WriteTo(&archive); err != nil {
+				t.Fatal(err)
+			}
+			if len(file.Manifest) != len(order) {
+				t.Fatalf("Expected %d manifest entries, but got %d", len(order), len(file.Manifest))
+			}
+			for i, entry := range file.Manifest {
+				sum := sha256.Sum256([]byte(contents[order[i]]))
+				if entry.Name != paths[i] || entry.Size != int64(len(contents[order[i]])) || entry.SHA256 != hex.EncodeToString(sum[:]) {
+					t.Fatalf("Expected entry of %s, but got %+v", paths[i], entry)
+				}
+				name, size, r, err := OpenFile(format, bytes.NewReader(archive.Bytes()), int64(archive.Len()), i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if name != paths[i] || size != entry.Size || string(content) != contents[order[i]] {
+					t.Fatalf("Expected %s with %q, but got %s with %q", paths[i], contents[order[i]], name, content)
+				}
+			}
+			if _, _, _, err := OpenFile(format, bytes.NewReader(archive.Bytes()), int64(archive.Len()), len(order)); err != ErrNoSuchFile {
+				t.Fatalf("Expected ErrNoSuchFile, but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const sealInfo = "dataShare sealed key"
+
+var ErrSealed = errors.New("can't open sealed content")
+
+// GenerateSealKey returns a new X25519 key pair, encoded as URL safe base64. Content sealed to the
+// public key can only be opened with the private key, which the server does not keep.
+func GenerateSealKey() (string, string, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.Bytes()), base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// SealPublicKey returns the public key of an encoded private key.
+func SealPublicKey(privateKey string) (string, error) {
+	priv, err := decodeSealPrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+func decodeSealPrivateKey(privateKey string) (*ecdh.PrivateKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(b)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return priv, nil
+}
+
+// sealCipher derives the AES-GCM cipher shared by the ephemeral and the recipient keys.
+func sealCipher(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(sealInfo)), key); err != nil {
+		return nil, err
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// Seal encrypts content to the public key with an ephemeral X25519 key. The result is the
+// ephemeral public key, the nonce and the ciphertext, encoded as URL safe base64.
+func Seal(publicKey string, content []byte) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(publicKey)
+	if err != nil {
+		return "", err
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return "", err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	aead, err := sealCipher(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	out.Write(ephemeral.PublicKey().Bytes())
+	out.Write(nonce)
+	out.Write(aead.Seal(nil, nonce, content, nil))
+	return base64.RawURLEncoding.EncodeToString(out.Bytes()), nil
+}
+
+// Open decrypts content sealed to the public key of privateKey.
+func Open(privateKey, sealed string) ([]byte, error) {
+	priv, err := decodeSealPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(b) < 32 {
+		return nil, ErrSealed
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(b[:32])
+	if err != nil {
+		return nil, ErrSealed
+	}
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	aead, err := sealCipher(shared, b[:32], priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	rest := b[32:]
+	if len(rest) < aead.NonceSize() {
+		return nil, ErrSealed
+	}
+	content, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrSealed
+	}
+	return content, nil
+}
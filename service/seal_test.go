@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	priv, pub, err := GenerateSealKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPriv, _, err := GenerateSealKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if derived, err := SealPublicKey(priv); err != nil || derived != pub {
+		t.Fatalf("Expected public key %s, but got %s (%v)", pub, derived, err)
+	}
+
+	sealed, err := Seal(pub, []byte("SomeKey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		priv     string
+		sealed   string
+		expected string
+		err      error
+	}{
+		{"RightKey", priv, sealed, "SomeKey", nil},
+		{"WrongKey", otherPriv, sealed, "", ErrSealed},
+		{"InvalidKey", "not a key", sealed, "", ErrSealed},
+		{"Truncated", priv, sealed[:40], "", ErrSealed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := Open(tt.priv, tt.sealed)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, but got %v", tt.err, err)
+			}
+			if string(content) != tt.expected {
+				t.Fatalf("Expected %q, but got %q", tt.expected, content)
+			}
+		})
+	}
+}
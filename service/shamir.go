@@ -0,0 +1,124 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxShares is the maximum number of shares a key can be split into.
+const MaxShares = 10
+
+var ErrInvalidShare = errors.New("invalid key share")
+
+// Arithmetic in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1, using log and exp tables of the generator 3.
+var gfExp, gfLog = func() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i], exp[i+255] = x, x
+		log[x] = byte(i)
+		// x *= 3
+		hi := x & 0x80
+		x2 := x << 1
+		if hi != 0 {
+			x2 ^= 0x1b
+		}
+		x ^= x2
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitKey splits key into n shares, any threshold of which rebuild it with CombineKey and fewer tell
+// nothing about it. Shares are formatted as "<index>-<hex>".
+func SplitKey(key string, n, threshold int) ([]string, error) {
+	if threshold < 2 || threshold > n || n > MaxShares {
+		return nil, fmt.Errorf("threshold must be between 2 and the number of shares, at most %d", MaxShares)
+	}
+	secret := []byte(key)
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+	coefficients := make([]byte, threshold-1)
+	for j, s := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			// Horner's evaluation of s + c1*x + ... + c(k-1)*x^(k-1) at x = i+1.
+			x, y := byte(i+1), byte(0)
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			shares[i][j] = gfMul(y, x) ^ s
+		}
+	}
+
+	result := make([]string, n)
+	for i, share := range shares {
+		result[i] = strconv.Itoa(i+1) + "-" + hex.EncodeToString(share)
+	}
+	return result, nil
+}
+
+// CombineKey rebuilds the key from shares created by SplitKey. With fewer shares than the threshold
+// the result is a wrong key, not an error.
+func CombineKey(shares []string) (string, error) {
+	xs := make([]byte, 0, len(shares))
+	ys := make([][]byte, 0, len(shares))
+	for _, share := range shares {
+		index, value, ok := strings.Cut(strings.TrimSpace(share), "-")
+		x, err := strconv.Atoi(index)
+		if !ok || err != nil || x < 1 || x > 255 {
+			return "", ErrInvalidShare
+		}
+		y, err := hex.DecodeString(value)
+		if err != nil || len(y) == 0 || (len(ys) > 0 && len(y) != len(ys[0])) {
+			return "", ErrInvalidShare
+		}
+		for _, other := range xs {
+			if other == byte(x) {
+				return "", ErrInvalidShare
+			}
+		}
+		xs = append(xs, byte(x))
+		ys = append(ys, y)
+	}
+	if len(xs) < 2 {
+		return "", ErrInvalidShare
+	}
+
+	// Lagrange interpolation at x = 0. In GF(2^8) subtraction is addition, so 0 - xj = xj.
+	secret := make([]byte, len(ys[0]))
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xs[j], xs[i]^xs[j]))
+			}
+		}
+		for b := range secret {
+			secret[b] ^= gfMul(ys[i][b], basis)
+		}
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitKey(t *testing.T) {
+	key := NewKey()
+	shares, err := SplitKey(key, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, but got %d", len(shares))
+	}
+
+	tests := []struct {
+		name   string
+		shares []string
+		match  bool
+	}{
+		{"Threshold", []string{shares[0], shares[2], shares[4]}, true},
+		{"OtherOrder", []string{shares[3], shares[1], shares[0]}, true},
+		{"All", shares, true},
+		{"BelowThreshold", []string{shares[0], shares[1]}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			combined, err := CombineKey(tt.shares)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (combined == key) != tt.match {
+				t.Fatalf("Expected match %v, but got key %q for %q", tt.match, combined, key)
+			}
+		})
+	}
+}
+
+func TestCombineKey_Invalid(t *testing.T) {
+	shares, err := SplitKey("SomeKey", 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		shares []string
+	}{
+		{"Single", []string{shares[0]}},
+		{"Duplicate", []string{shares[0], shares[0]}},
+		{"NoIndex", []string{shares[0], "abcdef"}},
+		{"NotHex", []string{shares[0], "2-xyz"}},
+		{"OtherLength", []string{shares[0], "2-ab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CombineKey(tt.shares); !errors.Is(err, ErrInvalidShare) {
+				t.Fatalf("Expected ErrInvalidShare, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestSplitKey_InvalidThreshold(t *testing.T) {
+	for _, tt := range []struct{ n, threshold int }{{3, 1}, {2, 3}, {MaxShares + 1, 2}} {
+		if _, err := SplitKey("SomeKey", tt.n, tt.threshold); err == nil {
+			t.Fatalf("Expected an error for %d of %d shares, but got none", tt.threshold, tt.n)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// sniffLength is the number of first bytes of a file its type is sniffed from.
+const sniffLength = 512
+
+// ContentCheck decides whether the file name, whose client claimed type is claimed, can be uploaded
+// given the type sniffed from its content. Its error cancels the upload.
+type ContentCheck func(name, claimed, sniffed string) error
+
+// signatures are the magic bytes of types http.DetectContentType doesn't know, executables first.
+var signatures = []struct {
+	offset      int
+	magic       []byte
+	contentType string
+}{
+	{0, []byte("MZ"), "application/x-msdownload"},
+	{0, []byte("\x7fELF"), "application/x-executable"},
+	{0, []byte("\xfe\xed\xfa\xce"), "application/x-mach-binary"},
+	{0, []byte("\xfe\xed\xfa\xcf"), "application/x-mach-binary"},
+	{0, []byte("\xce\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "application/x-mach-binary"},
+	{0, []byte("\xca\xfe\xba\xbe"), "application/x-mach-binary"},
+	{0, []byte("#!"), "text/x-shellscript"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "application/zstd"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "application/x-7z-compressed"},
+	{0, []byte("\xfd7zXZ\x00"), "application/x-xz"},
+	{257, []byte("ustar"), "application/x-tar"},
+}
+
+// SniffContentType returns the media type of content from its first bytes, without parameters.
+func SniffContentType(head []byte) string {
+	for _, s := range signatures {
+		if len(head) >= s.offset+len(s.magic) && bytes.Equal(head[s.offset:s.offset+len(s.magic)], s.magic) {
+			return s.contentType
+		}
+	}
+	return MediaType(http.DetectContentType(head))
+}
+
+// SniffReader returns the media type of the content read from r, from its first bytes.
+func SniffReader(r io.Reader) (string, error) {
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(r, head)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return SniffContentType(head[:n]), err
+}
+
+// MediaType returns the lower case media type of a Content-Type, without parameters.
+func MediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mediaType
+}
+
+// sniffWriter holds the first bytes of a file until its type is checked, nothing reaches w before.
+type sniffWriter struct {
+	w       io.Writer
+	head    []byte
+	checked bool
+	check   func(head []byte) error
+}
+
+func (s *sniffWriter) Write(p []byte) (int, error) {
+	if s.checked {
+		return s.w.Write(p)
+	}
+	n := min(len(p), sniffLength-len(s.head))
+	s.head = append(s.head, p[:n]...)
+	if len(s.head) < sniffLength {
+		return len(p), nil
+	}
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	if _, err := s.w.Write(p[n:]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush checks the type of the file and writes its first bytes, it must be called once the whole file was written.
+func (s *sniffWriter) flush() error {
+	if s.checked {
+		return nil
+	}
+	s.checked = true
+	if err := s.check(s.head); err != nil {
+		return err
+	}
+	_, err := s.w.Write(s.head)
+	return err
+}
+
+// copyChecked copies src to w, checked by check once its type is sniffed. It returns the sniffed type.
+func copyChecked(w io.Writer, src io.Reader, name, claimed string, check ContentCheck) (string, error) {
+	var sniffed string
+	sw := &sniffWriter{w: w, check: func(head []byte) error {
+		sniffed = SniffContentType(head)
+		if check == nil {
+			return nil
+		}
+		return check(name, claimed, sniffed)
+	}}
+	if _, err := io.Copy(sw, src); err != nil {
+		return "", err
+	}
+	if err := sw.flush(); err != nil {
+		return "", err
+	}
+	return sniffed, nil
+}
+
+// aliases map the media types some clients claim to the ones sniffed.
+var aliases = map[string]string{
+	"application/x-gzip":           "application/gzip",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-zip":            "application/zip",
+	"application/x-pdf":            "application/pdf",
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"audio/mp3":                    "audio/mpeg",
+	"audio/wav":                    "audio/wave",
+	"audio/x-wav":                  "audio/wave",
+}
+
+func canonicalType(mediaType string) string {
+	if alias, ok := aliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// MatchContentType reports whether the media type matches one of patterns, such as "application/pdf"
+// or "image/*".
+func MatchContentType(mediaType string, patterns []string) bool {
+	mediaType = canonicalType(MediaType(mediaType))
+	for _, pattern := range patterns {
+		pattern = canonicalType(MediaType(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return true
+		}
+		if pattern == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// CompatibleContentTypes reports whether content sniffed as sniffed can have the type claimed by the
+// client. Sniffing only tells a few types apart: any text matches a textual type, a zip archive the
+// formats based on zip, and media of the same kind match whatever their container.
+func CompatibleContentTypes(claimed, sniffed string) bool {
+	claimed, sniffed = canonicalType(MediaType(claimed)), canonicalType(MediaType(sniffed))
+	if claimed == "" || claimed == "application/octet-stream" || sniffed == "application/octet-stream" || claimed == sniffed {
+		return true
+	}
+	kind, _, _ := strings.Cut(claimed, "/")
+	switch {
+	case strings.HasPrefix(sniffed, "text/"):
+		return textual(claimed)
+	case sniffed == "application/zip":
+		return strings.HasSuffix(claimed, "+zip") || strings.HasPrefix(claimed, "application/vnd.openxmlformats-") ||
+			strings.HasPrefix(claimed, "application/vnd.oasis.opendocument.") || claimed == "application/java-archive" ||
+			claimed == "application/vnd.android.package-archive"
+	case kind == "image":
+		return strings.HasPrefix(sniffed, "image/")
+	case kind == "audio" || kind == "video":
+		// Containers such as mp4 and ogg hold both.
+		return strings.HasPrefix(sniffed, "audio/") || strings.HasPrefix(sniffed, "video/") || sniffed == "application/ogg"
+	}
+	return false
+}
+
+func textual(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"), strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/x-yaml", "application/yaml", "application/sql", "application/x-sh", "application/x-httpd-php",
+		"application/x-tex", "application/rtf", "application/x-ndjson":
+		return true
+	}
+	return false
+}
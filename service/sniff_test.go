@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+	tests := []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{"PE", []byte("MZ\x90\x00\x03\x00"), "application/x-msdownload"},
+		{"ELF", []byte("\x7fELF\x02\x01\x01"), "application/x-executable"},
+		{"MachO", []byte("\xcf\xfa\xed\xfe\x07\x00"), "application/x-mach-binary"},
+		{"Script", []byte("#!/bin/sh\necho hi\n"), "text/x-shellscript"},
+		{"Tar", tar, "application/x-tar"},
+		{"PDF", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "image/png"},
+		{"Zip", []byte("PK\x03\x04\x14\x00"), "application/zip"},
+		{"Text", []byte("hello world"), "text/plain"},
+		{"Empty", nil, "text/plain"},
+		{"Binary", []byte{0x00, 0x01, 0x02, 0xff}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sniffed := SniffContentType(tt.head); sniffed != tt.expected {
+				t.Fatalf("Expected %s, but got %s", tt.expected, sniffed)
+			}
+		})
+	}
+}
+
+func TestMatchContentType(t *testing.T) {
+	patterns := []string{"image/*", "application/pdf", "application/gzip"}
+	tests := []struct {
+		mediaType string
+		expected  bool
+	}{
+		{"image/png", true},
+		{"application/pdf", true},
+		{"Application/PDF; charset=binary", true},
+		{"application/x-gzip", true},
+		{"imagex/png", false},
+		{"application/zip", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mediaType, func(t *testing.T) {
+			if match := MatchContentType(tt.mediaType, patterns); match != tt.expected {
+				t.Fatalf("Expected match %v, but got %v", tt.expected, match)
+			}
+		})
+	}
+}
+
+func TestCompatibleContentTypes(t *testing.T) {
+	tests := []struct {
+		claimed  string
+		sniffed  string
+		expected bool
+	}{
+		{"", "application/x-msdownload", true},
+		{"application/octet-stream", "image/png", true},
+		{"image/png", "image/png", true},
+		{"image/jpg", "image/jpeg", true},
+		{"application/json", "text/plain", true},
+		{"text/csv; charset=utf-8", "text/plain", true},
+		{"image/svg+xml", "text/xml", true},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip", true},
+		{"audio/mp4", "video/mp4", true},
+		{"image/png", "application/x-msdownload", false},
+		{"application/pdf", "text/html", false},
+		{"text/plain", "application/pdf", false},
+		{"image/png", "video/mp4", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.claimed+" "+tt.sniffed, func(t *testing.T) {
+			if compatible := CompatibleContentTypes(tt.claimed, tt.sniffed); compatible != tt.expected {
+				t.Fatalf("Expected compatible %v, but got %v", tt.expected, compatible)
+			}
+		})
+	}
+}
+
+func TestCopyChecked(t *testing.T) {
+	errDenied := errors.New("denied")
+	deny := func(name, claimed, sniffed string) error {
+		if sniffed == "application/x-msdownload" {
+			return errDenied
+		}
+		return nil
+	}
+	tests := []struct {
+		name    string
+		content string
+		sniffed string
+		err     error
+	}{
+		{"Small", "hello", "text/plain", nil},
+		{"Large", strings.Repeat("a", 100<<10), "text/plain", nil},
+		{"Denied", "MZ" + strings.Repeat("\x00", 100<<10), "", errDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			sniffed, err := copyChecked(&w, strings.NewReader(tt.content), "file", "", deny)
+			if !errors.Is(err, tt.err) || sniffed != tt.sniffed {
+				t.Fatalf("Expected %s and error %v, but got %s and %v", tt.sniffed, tt.err, sniffed, err)
+			}
+			if tt.err != nil && w.Len() > 0 {
+				t.Fatalf("Expected nothing written before the check, but got %d bytes", w.Len())
+			}
+			if tt.err == nil && w.String() != tt.content {
+				t.Fatalf("Expected the whole content written")
+			}
+		})
+	}
+}
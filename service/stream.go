@@ -0,0 +1,205 @@
+package service
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The stream format encrypts content of any size without holding it in memory. After the salt of the
+// derived key, the content is split in chunks of streamChunkSize bytes, the last one being shorter and
+// possibly empty, each sealed with AES-GCM. The nonce of a chunk is its index, with a flag on the last one
+// so that a truncated stream can't be taken for a complete one. Nonces never repeat for a key, as every
+// stream has its own salt.
+const (
+	streamChunkSize = 64 << 10
+	streamNonceSize = 12
+	lastChunkFlag   = 1
+)
+
+var ErrStream = errors.New("invalid encrypted stream")
+
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, streamNonceSize)
+	binary.BigEndian.PutUint64(nonce[2:10], index)
+	if last {
+		nonce[streamNonceSize-1] = lastChunkFlag
+	}
+	return nonce
+}
+
+type streamWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	buf   []byte
+	index uint64
+}
+
+// EncryptStream returns a writer encrypting to w in the stream format, so that both the key and the passphrase
+// are needed to decrypt it. It must be closed to write the last chunk.
+func (e *Encryption) EncryptStream(key, passphrase string, w io.Writer) (io.WriteCloser, error) {
+	k, err := e.NewStreamKey(key, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(w)
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more content follows, the last chunk is sealed by Close.
+		if len(s.buf) == streamChunkSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):streamChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamWriter) seal(last bool) error {
+	_, err := s.w.Write(s.aead.Seal(nil, chunkNonce(s.index, last), s.buf, nil))
+	s.buf = s.buf[:0]
+	s.index++
+	return err
+}
+
+func (s *streamWriter) Close() error {
+	return s.seal(true)
+}
+
+type streamReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	pending int
+	out     []byte
+	plain   []byte
+	index   uint64
+	last    bool
+}
+
+// DecryptStream returns a reader decrypting the stream format read from r. It fails on the first chunk
+// when the key or the passphrase is wrong.
+func (e *Encryption) DecryptStream(key, passphrase string, r io.Reader) (io.Reader, error) {
+	salt := make([]byte, e.saltLength)
+	if _, err := io.ReadFull(r, salt); err != nil {
+		return nil, ErrStream
+	}
+	k, err := e.streamKey(key, passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return k.Decrypt(io.MultiReader(bytes.NewReader(salt), r))
+}
+
+// open decrypts the next chunk. One byte more than a full chunk is read to know whether it is the last one.
+func (s *streamReader) open() error {
+	n, err := io.ReadFull(s.r, s.chunk[s.pending:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	n += s.pending
+	size := streamChunkSize + s.aead.Overhead()
+	s.last = n <= size
+	if !s.last {
+		n = size
+	}
+	if n < s.aead.Overhead() {
+		return ErrStream
+	}
+	s.plain, err = s.aead.Open(s.out[:0], chunkNonce(s.index, s.last), s.chunk[:n], nil)
+	if err != nil {
+		return ErrStream
+	}
+	s.index++
+	if !s.last {
+		// The extra byte starts the next chunk.
+		s.chunk[0] = s.chunk[size]
+		s.pending = 1
+	}
+	return nil
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.last {
+			return 0, io.EOF
+		}
+		if err := s.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// StreamFile reads the plaintext of content in the stream format from any offset, only decrypting the
+// chunks from the one holding the offset.
+type StreamFile struct {
+	r          io.ReaderAt
+	aead       cipher.AEAD
+	saltLength int64
+	size       int64
+	plainSize  int64
+}
+
+// OpenStream opens the stream format read from r, of size bytes. The key is derived once, and checked
+// by decrypting the first chunk.
+func (e *Encryption) OpenStream(key, passphrase string, r io.ReaderAt, size int64) (*StreamFile, error) {
+	k, err := e.ReadStreamKey(key, passphrase, r)
+	if err != nil {
+		return nil, err
+	}
+	return k.OpenStream(r, size)
+}
+
+// plaintextSize computes the size of the plaintext from the size of the stream: every chunk is sealed
+// with the overhead of AES-GCM, and only the last one is shorter than a full chunk.
+func (s *StreamFile) plaintextSize() (int64, error) {
+	body := s.size - s.saltLength
+	sealedChunk := int64(streamChunkSize + s.aead.Overhead())
+	chunks, rest := body/sealedChunk, body%sealedChunk
+	if rest == 0 && chunks > 0 {
+		return chunks * streamChunkSize, nil
+	}
+	if rest < int64(s.aead.Overhead()) {
+		return 0, ErrStream
+	}
+	return chunks*streamChunkSize + rest - int64(s.aead.Overhead()), nil
+}
+
+// Size returns the size of the plaintext.
+func (s *StreamFile) Size() int64 {
+	return s.plainSize
+}
+
+// From returns a reader of the plaintext from offset on. It fails if the chunk holding the offset can't
+// be decrypted.
+func (s *StreamFile) From(offset int64) (io.Reader, error) {
+	if offset < 0 || (offset > 0 && offset >= s.plainSize) {
+		return nil, ErrStream
+	}
+	index := offset / streamChunkSize
+	start := s.saltLength + index*int64(streamChunkSize+s.aead.Overhead())
+	sr := &streamReader{
+		r:     io.NewSectionReader(s.r, start, s.size-start),
+		aead:  s.aead,
+		chunk: make([]byte, streamChunkSize+s.aead.Overhead()+1),
+		out:   make([]byte, 0, streamChunkSize),
+		index: uint64(index),
+	}
+	if err := sr.open(); err != nil {
+		return nil, err
+	}
+	sr.plain = sr.plain[offset-index*streamChunkSize:]
+	return sr, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+const metadataInfo = "dataShare metadata"
+
+// StreamKey is the key of a stream, derived from the key and the passphrase of a document with the salt
+// the stream starts with. The derivation is slow on purpose, so it is done once per upload or download:
+// a subkey of the same key seals the metadata of the content, such as its SHA-256.
+type StreamKey struct {
+	salt []byte
+	aead cipher.AEAD
+	meta cipher.AEAD
+}
+
+// NewStreamKey derives a stream key with a new salt.
+func (e *Encryption) NewStreamKey(key, passphrase string) (*StreamKey, error) {
+	return e.streamKey(key, passphrase, nil)
+}
+
+// ReadStreamKey derives the key of the stream read from r, with the salt at its start.
+func (e *Encryption) ReadStreamKey(key, passphrase string, r io.ReaderAt) (*StreamKey, error) {
+	salt := make([]byte, e.saltLength)
+	if _, err := r.ReadAt(salt, 0); err != nil {
+		return nil, ErrStream
+	}
+	return e.streamKey(key, passphrase, salt)
+}
+
+// SealedKey derives the key content was sealed with, with the salt at its start.
+func (e *Encryption) SealedKey(key, passphrase string, sealed []byte) (*StreamKey, error) {
+	if len(sealed) < e.saltLength {
+		return nil, ErrStream
+	}
+	return e.streamKey(key, passphrase, sealed[:e.saltLength])
+}
+
+func (e *Encryption) streamKey(key, passphrase string, salt []byte) (*StreamKey, error) {
+	derivedKey, salt := e.deriveKey(key, passphrase, salt)
+	aead, err := newGCM(derivedKey)
+	if err != nil {
+		return nil, err
+	}
+	subkey := make([]byte, len(derivedKey))
+	if _, err := io.ReadFull(hkdf.New(sha256.New, derivedKey, salt, []byte(metadataInfo)), subkey); err != nil {
+		return nil, err
+	}
+	meta, err := newGCM(subkey)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamKey{salt: salt, aead: aead, meta: meta}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// Encrypt returns a writer encrypting to w in the stream format. It must be closed to write the last chunk.
+func (k *StreamKey) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	if _, err := w.Write(k.salt); err != nil {
+		return nil, err
+	}
+	return &streamWriter{w: w, aead: k.aead, buf: make([]byte, 0, streamChunkSize)}, nil
+}
+
+// Decrypt returns a reader decrypting the stream format read from r, which must start with the salt of
+// the key. It fails on the first chunk when the key is wrong.
+func (k *StreamKey) Decrypt(r io.Reader) (io.Reader, error) {
+	salt := make([]byte, len(k.salt))
+	if _, err := io.ReadFull(r, salt); err != nil || !bytes.Equal(salt, k.salt) {
+		return nil, ErrStream
+	}
+	s := &streamReader{
+		r:     r,
+		aead:  k.aead,
+		chunk: make([]byte, streamChunkSize+k.aead.Overhead()+1),
+		out:   make([]byte, 0, streamChunkSize),
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenStream opens the stream format read from r, of size bytes, which must start with the salt of the
+// key. The key is checked by decrypting the first chunk.
+func (k *StreamKey) OpenStream(r io.ReaderAt, size int64) (*StreamFile, error) {
+	salt := make([]byte, len(k.salt))
+	if _, err := r.ReadAt(salt, 0); err != nil || !bytes.Equal(salt, k.salt) {
+		return nil, ErrStream
+	}
+	s := &StreamFile{r: r, aead: k.aead, saltLength: int64(len(k.salt)), size: size}
+	var err error
+	if s.plainSize, err = s.plaintextSize(); err != nil {
+		return nil, err
+	}
+	if _, err := s.From(0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Seal encrypts small content, such as the SHA-256 of the stream, with the subkey. The result is the
+// salt, so that the key can be derived without the stream, the nonce and the ciphertext.
+func (k *StreamKey) Seal(content []byte) ([]byte, error) {
+	nonce := make([]byte, k.meta.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := append(append([]byte{}, k.salt...), nonce...)
+	return k.meta.Seal(sealed, nonce, content, nil), nil
+}
+
+// Seals reports whether sealed was sealed with a key derived with the same salt.
+func (k *StreamKey) Seals(sealed []byte) bool {
+	return len(sealed) >= len(k.salt) && bytes.Equal(sealed[:len(k.salt)], k.salt)
+}
+
+// Open decrypts content sealed by Seal with the same key.
+func (k *StreamKey) Open(sealed []byte) ([]byte, error) {
+	if !k.Seals(sealed) || len(sealed) < len(k.salt)+k.meta.NonceSize() {
+		return nil, ErrStream
+	}
+	nonce, ciphertext := sealed[len(k.salt):len(k.salt)+k.meta.NonceSize()], sealed[len(k.salt)+k.meta.NonceSize():]
+	content, err := k.meta.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrStream
+	}
+	return content, nil
+}
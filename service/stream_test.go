@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestEncryptStream(t *testing.T) {
+	e := NewEncryption(1000, 32, 16, "salt")
+	tests := []struct {
+		name string
+		size int
+	}{
+		{"Empty", 0},
+		{"Small", 100},
+		{"FullChunk", streamChunkSize},
+		{"Chunks", 3*streamChunkSize + 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := make([]byte, tt.size)
+			rand.Read(content)
+			var encrypted bytes.Buffer
+			w, err := e.EncryptStream("key", "passphrase", &encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Uneven writes must not change the chunks.
+			for rest := content; len(rest) > 0; {
+				n := min(len(rest), 1000)
+				if _, err := w.Write(rest[:n]); err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := e.DecryptStream("key", "passphrase", bytes.NewReader(encrypted.Bytes()))
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			decrypted, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(decrypted, content) {
+				t.Fatalf("Expected the content back, but got %d bytes and %v", len(decrypted), err)
+			}
+
+			if _, err := e.DecryptStream("key", "wrong passphrase", bytes.NewReader(encrypted.Bytes())); err == nil {
+				t.Fatal("Expected an error with the wrong passphrase")
+			}
+			if tt.size > streamChunkSize {
+				// Dropping the last chunk must be detected.
+				truncated := encrypted.Bytes()[:16+streamChunkSize+16]
+				r, err := e.DecryptStream("key", "passphrase", bytes.NewReader(truncated))
+				if err == nil {
+					_, err = io.ReadAll(r)
+				}
+				if err == nil {
+					t.Fatal("Expected an error with a truncated stream")
+				}
+			}
+		})
+	}
+}
+
+func TestOpenStream(t *testing.T) {
+	e := NewEncryption(1000, 32, 16, "salt")
+	tests := []struct {
+		name    string
+		size    int
+		offsets []int64
+	}{
+		{"Empty", 0, []int64{0}},
+		{"Small", 100, []int64{0, 1, 99}},
+		{"FullChunk", streamChunkSize, []int64{0, streamChunkSize - 1}},
+		{"Chunks", 3*streamChunkSize + 17, []int64{0, streamChunkSize - 1, streamChunkSize, 2*streamChunkSize + 5, 3 * streamChunkSize, 3*streamChunkSize + 16}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := make([]byte, tt.size)
+			rand.Read(content)
+			var encrypted bytes.Buffer
+			w, err := e.EncryptStream("key", "passphrase", &encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Write(content)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			stored := bytes.NewReader(encrypted.Bytes())
+
+			s, err := e.OpenStream("key", "passphrase", stored, stored.Size())
+			if err != nil {
+				t.Fatalf("Expected no error, but got %v", err)
+			}
+			if s.Size() != int64(tt.size) {
+				t.Fatalf("Expected size %d, but got %d", tt.size, s.Size())
+			}
+			for _, offset := range tt.offsets {
+				r, err := s.From(offset)
+				if err != nil {
+					t.Fatalf("Expected no error from %d, but got %v", offset, err)
+				}
+				rest, err := io.ReadAll(r)
+				if err != nil || !bytes.Equal(rest, content[offset:]) {
+					t.Fatalf("Expected the content from %d, but got %d bytes and %v", offset, len(rest), err)
+				}
+			}
+			if _, err := s.From(int64(tt.size) + 1); err == nil {
+				t.Fatal("Expected an error past the end of the content")
+			}
+			if _, err := e.OpenStream("key", "wrong passphrase", stored, stored.Size()); err == nil {
+				t.Fatal("Expected an error with the wrong passphrase")
+			}
+		})
+	}
+}
+
+func TestStreamKey_Seal(t *testing.T) {
+	e := NewEncryption(1000, 32, 16, "salt")
+	k, err := e.NewStreamKey("key", "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var encrypted bytes.Buffer
+	w, err := k.Encrypt(&encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("content"))
+	w.Close()
+	sealed, err := k.Seal([]byte("digest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream and its metadata share the key, derived once when downloaded.
+	stored := bytes.NewReader(encrypted.Bytes())
+	read, err := e.ReadStreamKey("key", "passphrase", stored)
+	if err != nil || !read.Seals(sealed) {
+		t.Fatalf("Expected the key of the stream to seal the metadata, but got %v", err)
+	}
+	if content, err := read.Open(sealed); err != nil || string(content) != "digest" {
+		t.Fatalf("Expected digest, but got %q and %v", content, err)
+	}
+	if _, err := read.OpenStream(stored, stored.Size()); err != nil {
+		t.Fatalf("Expected the stream to open, but got %v", err)
+	}
+
+	// Without the stream the key is derived with the salt of the sealed metadata.
+	if k, err := e.SealedKey("key", "passphrase", sealed); err != nil {
+		t.Fatal(err)
+	} else if content, err := k.Open(sealed); err != nil || string(content) != "digest" {
+		t.Fatalf("Expected digest, but got %q and %v", content, err)
+	}
+	if k, err := e.SealedKey("key", "wrong passphrase", sealed); err != nil {
+		t.Fatal(err)
+	} else if _, err := k.Open(sealed); err == nil {
+		t.Fatal("Expected an error with the wrong passphrase")
+	}
+	other, _ := e.NewStreamKey("key", "passphrase")
+	if other.Seals(sealed) {
+		t.Fatal("Expected a key with another salt not to seal the metadata")
+	}
+	if _, err := other.Open(sealed); err == nil {
+		t.Fatal("Expected an error with a key of another salt")
+	}
+}
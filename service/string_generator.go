@@ -54,3 +54,8 @@ func GenerateAuthKey() (string, error) {
 func GenerateCSRFSecret() (string, error) {
 	return generateAndEncodeKey(32, hex.EncodeToString)
 }
+
+// GenerateManageToken returns the URL safe token giving a sender access to the state of a document.
+func GenerateManageToken() (string, error) {
+	return generateAndEncodeKey(24, base64.RawURLEncoding.EncodeToString)
+}
@@ -0,0 +1,231 @@
+package sso
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	stateCookie = "datashare_oidc"
+	stateTTL    = 10 * time.Minute
+)
+
+var (
+	ErrState    = errors.New("invalid or expired login state")
+	ErrDomain   = errors.New("email domain is not allowed")
+	ErrGroup    = errors.New("user is not in an allowed group")
+	ErrNoEmail  = errors.New("identity provider did not return a verified email")
+	ErrExchange = errors.New("can't exchange authorization code")
+)
+
+type Config struct {
+	IssuerURL      string
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string
+	Scopes         []string
+	AllowedDomains []string
+	AllowedGroups  []string
+	GroupsClaim    string
+}
+
+// Identity is the verified user returned by the identity provider, identified by its issuer and subject.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// Provider runs the OpenID Connect authorization code flow with PKCE.
+// The state, nonce and PKCE verifier of a login in progress are kept in an HMAC signed cookie.
+type Provider struct {
+	cfg      Config
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	secret   []byte
+	secure   bool
+}
+
+// NewProvider discovers the identity provider configuration from the issuer URL.
+func NewProvider(ctx context.Context, cfg Config, secret []byte, secure bool) (*Provider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("can't discover OIDC provider %s: %w", cfg.IssuerURL, err)
+	}
+	scopes := append([]string{oidc.ScopeOpenID}, cfg.Scopes...)
+	return &Provider{
+		cfg: cfg,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		secret:   secret,
+		secure:   secure,
+	}, nil
+}
+
+type loginState struct {
+	State     string `json:"s"`
+	Nonce     string `json:"n"`
+	Verifier  string `json:"v"`
+	ExpiresAt int64  `json:"e"`
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (p *Provider) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *Provider) setStateCookie(c echo.Context, value string, expires time.Time) {
+	c.SetCookie(&http.Cookie{
+		Name:     stateCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   p.secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Login starts the flow and redirects the browser to the identity provider.
+func (p *Provider) Login(c echo.Context) error {
+	state, err := randomString()
+	if err != nil {
+		return err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return err
+	}
+	ls := loginState{State: state, Nonce: nonce, Verifier: oauth2.GenerateVerifier(), ExpiresAt: time.Now().Add(stateTTL).Unix()}
+	content, err := json.Marshal(ls)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(content)
+	p.setStateCookie(c, payload+"."+p.sign(payload), time.Unix(ls.ExpiresAt, 0))
+
+	url := p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(ls.Verifier))
+	return c.Redirect(http.StatusFound, url)
+}
+
+func (p *Provider) readState(c echo.Context) (*loginState, error) {
+	cookie, err := c.Cookie(stateCookie)
+	if err != nil {
+		return nil, ErrState
+	}
+	p.setStateCookie(c, "", time.Unix(0, 0))
+
+	payload, signature, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(p.sign(payload))) {
+		return nil, ErrState
+	}
+	content, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrState
+	}
+	var ls loginState
+	if err := json.Unmarshal(content, &ls); err != nil || time.Now().Unix() > ls.ExpiresAt {
+		return nil, ErrState
+	}
+	if !hmac.Equal([]byte(ls.State), []byte(c.QueryParam("state"))) {
+		return nil, ErrState
+	}
+	return &ls, nil
+}
+
+// Callback completes the flow: it exchanges the code, verifies the ID token and checks
+// that the user belongs to an allowed domain and group.
+func (p *Provider) Callback(c echo.Context) (*Identity, error) {
+	ls, err := p.readState(c)
+	if err != nil {
+		return nil, err
+	}
+	if e := c.QueryParam("error"); e != "" {
+		return nil, fmt.Errorf("identity provider error: %s", e)
+	}
+
+	ctx := c.Request().Context()
+	token, err := p.oauth2.Exchange(ctx, c.QueryParam("code"), oauth2.VerifierOption(ls.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchange, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(ls.Nonce)) {
+		return nil, errors.New("invalid ID token nonce")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+	return p.authorize(idToken.Subject, claims)
+}
+
+// authorize checks the claims of a verified ID token against the allowed domains and groups.
+func (p *Provider) authorize(subject string, claims map[string]interface{}) (*Identity, error) {
+	email, _ := claims["email"].(string)
+	// Providers that don't verify emails leave out email_verified, their emails can't be trusted.
+	verified, _ := claims["email_verified"].(bool)
+	if email == "" || !verified {
+		return nil, ErrNoEmail
+	}
+	identity := &Identity{Issuer: p.cfg.IssuerURL, Subject: subject, Email: strings.ToLower(email)}
+
+	if len(p.cfg.AllowedDomains) > 0 {
+		_, domain, _ := strings.Cut(identity.Email, "@")
+		if !slices.ContainsFunc(p.cfg.AllowedDomains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+			return nil, ErrDomain
+		}
+	}
+
+	if groups, ok := claims[p.cfg.GroupsClaim].([]interface{}); ok {
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, s)
+			}
+		}
+	}
+	if len(p.cfg.AllowedGroups) > 0 {
+		if !slices.ContainsFunc(identity.Groups, func(g string) bool { return slices.Contains(p.cfg.AllowedGroups, g) }) {
+			return nil, ErrGroup
+		}
+	}
+	return identity, nil
+}
@@ -0,0 +1,191 @@
+package sso
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type mockProvider struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	claims   map[string]interface{}
+	nonce    string
+	verifier string
+	code     string
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, code: "test-code"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+			http.Error(w, "PKCE required", http.StatusBadRequest)
+			return
+		}
+		m.nonce = q.Get("nonce")
+		m.verifier = q.Get("code_challenge")
+		http.Redirect(w, r, q.Get("redirect_uri")+"?code="+m.code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if r.PostForm.Get("code") != m.code || base64.RawURLEncoding.EncodeToString(sum[:]) != m.verifier {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.idToken(t),
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T) string {
+	claims := map[string]interface{}{
+		"iss":   m.server.URL,
+		"sub":   "user-1",
+		"aud":   "client",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": m.nonce,
+	}
+	for k, v := range m.claims {
+		claims[k] = v
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// login runs the full flow against the mock provider and returns the callback result.
+func login(t *testing.T, m *mockProvider, p *Provider, tamperState bool) (*Identity, error) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil)
+	rec := httptest.NewRecorder()
+	if err := p.Login(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Query().Get("code") == "" {
+		t.Fatalf("Expected redirect with code, but got %q", resp.Header.Get("Location"))
+	}
+	if tamperState {
+		q := callback.Query()
+		q.Set("state", "other")
+		callback.RawQuery = q.Encode()
+	}
+
+	req = httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return p.Callback(e.NewContext(req, httptest.NewRecorder()))
+}
+
+func TestProvider(t *testing.T) {
+	tests := []struct {
+		name          string
+		claims        map[string]interface{}
+		domains       []string
+		groups        []string
+		tamperState   bool
+		expectedErr   error
+		expectedEmail string
+	}{
+		{"allowed", map[string]interface{}{"email": "Alice@Example.com", "email_verified": true}, []string{"example.com"}, nil, false, nil, "alice@example.com"},
+		{"wrong domain", map[string]interface{}{"email": "bob@other.com", "email_verified": true}, []string{"example.com"}, nil, false, ErrDomain, ""},
+		{"unverified email", map[string]interface{}{"email": "alice@example.com", "email_verified": false}, nil, nil, false, ErrNoEmail, ""},
+		{"no email_verified", map[string]interface{}{"email": "alice@example.com"}, nil, nil, false, ErrNoEmail, ""},
+		{"allowed group", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "groups": []string{"staff", "senders"}}, nil, []string{"senders"}, false, nil, "alice@example.com"},
+		{"missing group", map[string]interface{}{"email": "alice@example.com", "email_verified": true, "groups": []string{"staff"}}, nil, []string{"senders"}, false, ErrGroup, ""},
+		{"tampered state", map[string]interface{}{"email": "alice@example.com", "email_verified": true}, nil, nil, true, ErrState, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = test.claims
+			p, err := NewProvider(context.Background(), Config{
+				IssuerURL:      m.server.URL,
+				ClientID:       "client",
+				RedirectURL:    "http://datashare.test/auth/oidc/callback",
+				Scopes:         []string{"email"},
+				AllowedDomains: test.domains,
+				AllowedGroups:  test.groups,
+				GroupsClaim:    "groups",
+			}, []byte("secret"), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			identity, err := login(t, m, p, test.tamperState)
+			if !errors.Is(err, test.expectedErr) {
+				t.Fatalf("Expected error %v, but got %v", test.expectedErr, err)
+			}
+			if err == nil && identity.Email != test.expectedEmail {
+				t.Fatalf("Expected email %s, but got %s", test.expectedEmail, identity.Email)
+			}
+			if err == nil && (identity.Issuer != m.server.URL || identity.Subject != "user-1") {
+				t.Fatalf("Expected identity %s user-1, but got %s %s", m.server.URL, identity.Issuer, identity.Subject)
+			}
+		})
+	}
+}
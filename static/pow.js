@@ -0,0 +1,62 @@
+// Solves the proof-of-work challenge required before submitting a form with the data-pow attribute.
+// The solution is a counter such that SHA-256(challenge + ":" + counter) starts with
+// `difficulty` zero bits.
+(function () {
+    function leadingZeroBits(bytes) {
+        let n = 0;
+        for (const b of bytes) {
+            if (b !== 0) {
+                return n + Math.clz32(b) - 24;
+            }
+            n += 8;
+        }
+        return n;
+    }
+
+    async function solve(challenge, difficulty) {
+        const encoder = new TextEncoder();
+        for (let i = 0; ; i++) {
+            const digest = await crypto.subtle.digest("SHA-256", encoder.encode(challenge + ":" + i));
+            if (leadingZeroBits(new Uint8Array(digest)) >= difficulty) {
+                return String(i);
+            }
+        }
+    }
+
+    for (const form of document.querySelectorAll("form[data-pow]")) {
+        form.addEventListener("submit", async function (event) {
+            if (form.dataset.powSolved) {
+                return;
+            }
+            event.preventDefault();
+            // form.submit() doesn't send the button that was clicked, such as the export one.
+            const submitter = event.submitter;
+            const submit = form.querySelector("input[type=submit]");
+            const label = submit.value;
+            submit.disabled = true;
+            submit.value = "Working...";
+            try {
+                const response = await fetch("/pow/challenge?scope=" + form.dataset.pow);
+                if (!response.ok) {
+                    throw new Error("can't get challenge");
+                }
+                const challenge = await response.json();
+                form.querySelector("input[name=pow_challenge]").value = challenge.challenge;
+                form.querySelector("input[name=pow_solution]").value = await solve(challenge.challenge, challenge.difficulty);
+                form.dataset.powSolved = "true";
+                if (submitter && submitter.name) {
+                    const input = document.createElement("input");
+                    input.type = "hidden";
+                    input.name = submitter.name;
+                    input.value = submitter.value;
+                    form.appendChild(input);
+                }
+                form.submit();
+            } catch (e) {
+                submit.disabled = false;
+                submit.value = label;
+                alert("Something went wrong, try again");
+            }
+        });
+    }
+})();
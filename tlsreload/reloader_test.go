@@ -0,0 +1,174 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned generates a self-signed certificate for localhost and writes it to certFile and keyFile.
+func writeSelfSigned(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("can't generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("can't create certificate: %s", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("can't marshal key: %s", err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	for name, content := range map[string][]byte{certFile: certPem, keyFile: keyPem} {
+		if err := os.WriteFile(name, content, 0600); err != nil {
+			t.Fatalf("can't write %s: %s", name, err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatalf("can't touch %s: %s", name, err)
+		}
+	}
+}
+
+func serial(t *testing.T, r *Reloader) int64 {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("can't parse certificate: %s", err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, 1, time.Now().Add(-time.Minute))
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	if s := serial(t, r); s != 1 {
+		t.Fatalf("Expected serial 1, but got %d", s)
+	}
+
+	writeSelfSigned(t, certFile, keyFile, 2, time.Now())
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	if s := serial(t, r); s != 2 {
+		t.Fatalf("Expected serial 2, but got %d", s)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Expected error for a broken key, but got nothing")
+	}
+	if s := serial(t, r); s != 2 {
+		t.Fatalf("Expected previous certificate to be kept, but got serial %d", s)
+	}
+}
+
+func TestReloader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, 1, time.Now().Add(-time.Minute))
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	writeSelfSigned(t, certFile, keyFile, 2, time.Now())
+	deadline := time.Now().Add(2 * time.Second)
+	for serial(t, r) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected certificate to be reloaded after file change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloader_Serve(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSigned(t, certFile, keyFile, 7, time.Now())
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = r.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+	// Send SNI so the server consults GetCertificate instead of the httptest certificate.
+	client.Transport.(*http.Transport).TLSClientConfig.ServerName = "localhost"
+	client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected no error but got: '%s'", err)
+	}
+	defer resp.Body.Close()
+	if got := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); got != 7 {
+		t.Fatalf("Expected serial 7, but got %d", got)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     int
+		host     string
+		location string
+	}{
+		{"DefaultPort", 443, "example.com:80", "https://example.com/abc?x=1"},
+		{"CustomPort", 8443, "example.com:8080", "https://example.com:8443/abc?x=1"},
+		{"IPv6", 443, "[::1]:80", "https://[::1]/abc?x=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/abc?x=1", nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			RedirectHandler(tt.port).ServeHTTP(rec, req)
+			if rec.Code != http.StatusMovedPermanently {
+				t.Fatalf("Expected status %d, but got %d", http.StatusMovedPermanently, rec.Code)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Fatalf("Expected location '%s', but got '%s'", tt.location, got)
+			}
+		})
+	}
+}
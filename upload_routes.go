@@ -0,0 +1,158 @@
+package main
+
+import (
+	"dataShare/config"
+	"dataShare/core"
+	"dataShare/document"
+	"encoding/base64"
+	"github.com/labstack/echo/v4"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads implement the core protocol of tus 1.0.0 with its creation and termination extensions,
+// see https://tus.io/protocols/resumable-upload. Once every chunk is received, the upload is encrypted into
+// a document by a POST to /api/v1/uploads/:id/document with the fields of the upload form but the files.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	tusChunkType  = "application/offset+octet-stream"
+)
+
+// tusResumable sets the protocol version on every response, and rejects the requests of clients
+// speaking another version. OPTIONS requests are sent before the version is known.
+func tusResumable(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Tus-Resumable", tusVersion)
+		if c.Request().Method != http.MethodOptions && c.Request().Header.Get("Tus-Resumable") != tusVersion {
+			c.Response().Header().Set("Tus-Version", tusVersion)
+			return c.NoContent(http.StatusPreconditionFailed)
+		}
+		return next(c)
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma separated keys, each followed by
+// its base64 encoded value when it has one.
+func parseUploadMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(decoded)
+	}
+	return metadata
+}
+
+func uploadOptions(c echo.Context) error {
+	c.Response().Header().Set("Tus-Version", tusVersion)
+	c.Response().Header().Set("Tus-Extension", tusExtensions)
+	c.Response().Header().Set("Tus-Max-Size", strconv.Itoa(document.MaxUploadFileSize))
+	return c.NoContent(http.StatusNoContent)
+}
+
+func createUpload(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	length, err := strconv.ParseInt(c.Request().Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		length = 0
+	}
+	metadata := parseUploadMetadata(c.Request().Header.Get("Upload-Metadata"))
+	u, err := h.CreateUpload(length, metadata["filename"], metadata["filetype"])
+	if err != nil {
+		return apiError(c, err)
+	}
+
+	cfg := c.Get("config").(*config.Config)
+	c.Response().Header().Set("Location", cfg.App.BaseURL+"/api/v1/uploads/"+u.ID)
+	return c.NoContent(http.StatusCreated)
+}
+
+// uploadOffset tells the client where to resume the upload.
+func uploadOffset(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	u, err := h.Upload(c.Param("id"))
+	if err != nil {
+		return c.NoContent(http.StatusNotFound)
+	}
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	c.Response().Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	return c.NoContent(http.StatusOK)
+}
+
+func writeUpload(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	if c.Request().Header.Get("Content-Type") != tusChunkType {
+		return c.NoContent(http.StatusUnsupportedMediaType)
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	u, err := h.WriteUpload(c.Param("id"), offset, c.Request().Body)
+	if u != nil {
+		c.Response().Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	}
+	if err != nil {
+		return apiError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func deleteUpload(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	if err := h.DeleteUpload(c.Param("id")); err != nil {
+		return apiError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// finishUpload encrypts the complete upload and answers like the upload of a document.
+func finishUpload(c echo.Context) error {
+	h, err := NewDocumentHandler(c)
+	if err != nil {
+		return apiError(c, err)
+	}
+	params, err := c.FormParams()
+	if err != nil {
+		return apiError(c, core.NewError(http.StatusBadRequest, 1000, "Invalid form"))
+	}
+	idKey, err := h.FinishUpload(c.Param("id"), &multipart.Form{Value: params})
+	if err != nil {
+		return apiError(c, err)
+	}
+	return c.JSON(http.StatusCreated, newUploadResponse(c.Get("config").(*config.Config), idKey))
+}
+
+// registerUploadRoutes adds the resumable upload endpoints to the API. Only the creation of an upload
+// goes through the upload middlewares, its chunks are authorized by the unguessable upload ID.
+func registerUploadRoutes(api *echo.Group, uploadMiddlewares []echo.MiddlewareFunc) {
+	api.POST("/uploads/:id/document", finishUpload)
+	g := api.Group("/uploads", tusResumable)
+	g.OPTIONS("", uploadOptions)
+	g.POST("", createUpload, uploadMiddlewares...)
+	g.HEAD("/:id", uploadOffset)
+	g.PATCH("/:id", writeUpload)
+	g.DELETE("/:id", deleteUpload)
+}